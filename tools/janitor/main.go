@@ -15,16 +15,35 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 
+	"cloud.google.com/go/pubsub"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/addresses"
 	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/disks"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/eventjanitor"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/firewalls"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/forwardingrules"
 	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/images"
 	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/instances"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/networks"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/snapshots"
 	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -33,14 +52,22 @@ import (
 )
 
 // BlacklistConfig stores a list of resources that should be ignored during
-// deletion.
+// deletion. Instances and Images are regex name blacklists used by the AWS
+// and Azure providers; Rules is the GCE provider's Prometheus-style
+// relabeling configuration, keyed on resource labels rather than names.
 type BlacklistConfig struct {
 	Instances []string `yaml:"instances"`
 	Images    []string `yaml:"images"`
+	Rules     struct {
+		Instances []*relabel.Config `yaml:"instances"`
+		Images    []*relabel.Config `yaml:"images"`
+	} `yaml:"rules"`
 }
 
 func main() {
-	project := flag.String("project", "foo", "ID of the project to clean up")
+	provider := flag.String("provider", "gce", "Cloud provider to clean up: one of gce, aws, azure")
+	project := flag.String("project", "foo", "ID of the GCE project, AWS region, or Azure subscription to clean up")
+	azureResourceGroup := flag.String("azure-resource-group", "", "Azure resource group to clean up. Required when --provider=azure")
 	nameDelimiter := flag.String("image-delimiter", "-", "Delimiter used to separate parts of resource name")
 	workers := flag.Int("workers", 10, "Delimiter used to separate parts of the resource name")
 	olderThan := flag.Int64("older-than", 2592000, "Time in seconds that resources should not be older than")
@@ -50,9 +77,22 @@ func main() {
 	deleteSingletons := flag.Bool("delete-singletons", false, "If set, all resources that are older than the time specified will be deleted regardless of whether they are the only resource of a certain name.")
 	logType := flag.String("log-type", "text", "If set, all resources that are older than the time specified will be deleted regardless of whether they are the only resource of a certain name.")
 	notDryRun := flag.Bool("not-dry-run", false, "Logs the changes that will be made without taking any actions.")
+	mode := flag.String("mode", "batch", "How the janitor is run: 'batch' sweeps the project on demand, 'event' runs as a long-lived controller reacting to a Pub/Sub subscription. Only supported with --provider=gce.")
+	pubsubSubscription := flag.String("pubsub-subscription", "", "Pub/Sub subscription, fed by a Compute Engine audit log sink, to read compute.instances.insert events from. Required when --mode=event.")
+	force := flag.Bool("force", false, "If set, GCE instances with deletionProtection enabled are included in the candidate list instead of excluded. Only supported with --provider=gce.")
+	metricsAddr := flag.String("metrics-addr", "", "If set, serve Prometheus metrics on this address (e.g. :9090) for the duration of the run.")
 
 	flag.Parse()
 
+	if *metricsAddr != "" {
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				log.WithField("error", err).Fatal("Unable to serve metrics")
+			}
+		}()
+	}
+
 	if *logFile != "" {
 		file, err := os.Create(*logFile)
 		if err != nil {
@@ -97,24 +137,181 @@ func main() {
 		}
 	}
 
-	compute, err := initClient()
+	tooOld := utils.GetTooOldTime(*olderThan)
+
+	if *mode == "event" {
+		if *provider != string(images.GCE) {
+			log.Fatalf("main.go: --mode=event is only supported with --provider=gce")
+		}
+		if *pubsubSubscription == "" {
+			log.Fatalf("main.go: --pubsub-subscription is required when --mode=event")
+		}
+
+		computeSvc, err := initGCEClient()
+		if err != nil {
+			log.Fatalf("main.go: unable to initialize gce client: %s", err)
+		}
+
+		if err := runEventJanitor(context.Background(), computeSvc, *project, *pubsubSubscription, time.Duration(*olderThan)*time.Second, !*notDryRun); err != nil {
+			log.Fatalf("main.go: event janitor exited with an error: %s", err)
+		}
+		return
+	}
+
+	config := janitorConfig{
+		Provider:           images.Provider(*provider),
+		Project:            *project,
+		AzureResourceGroup: *azureResourceGroup,
+		ExpiredBefore:      tooOld,
+		DeleteSingletons:   *deleteSingletons,
+		Blacklist:          blacklistConfig,
+		NameDelimiter:      *nameDelimiter,
+		Force:              *force,
+	}
+
+	imtd, intd, gcetd, err := newJanitorMetadata(config)
 	if err != nil {
-		log.Fatalf("main.go: unable to initialize Compute Engine client: %s", err)
+		log.Fatalf("main.go: unable to initialize %s clients: %s", *provider, err)
 	}
 
-	tooOld := utils.GetTooOldTime(*olderThan)
+	// Stage 1: images and the resources with no dependents (forwarding
+	// rules, firewalls) can all be cleaned up in parallel.
+	var stageOne sync.WaitGroup
+	stageOne.Add(1)
+	go deleteImages(imtd, *workers, *notDryRun, &stageOne)
+	if gcetd != nil {
+		stageOne.Add(2)
+		go deleteForwardingRules(gcetd.ForwardingRules, *workers, *notDryRun, &stageOne)
+		go deleteFirewalls(gcetd.Firewalls, *workers, *notDryRun, &stageOne)
+	}
+	stageOne.Wait()
+
+	// Stage 2: instances depend on nothing deleted above, but disks
+	// attached to them must outlive them, so instances go first. Static
+	// addresses run alongside them, after the forwarding rules that held
+	// them are gone.
+	var stageTwo sync.WaitGroup
+	stageTwo.Add(1)
+	go deleteInstances(intd, *workers, *notDryRun, &stageTwo)
+	if gcetd != nil {
+		stageTwo.Add(1)
+		go deleteAddresses(gcetd.Addresses, *workers, *notDryRun, &stageTwo)
+	}
+	stageTwo.Wait()
+
+	if gcetd == nil {
+		return
+	}
+
+	// Stage 3: disks orphaned by the instances deleted above are now
+	// eligible.
+	deleteDisks(gcetd.Disks, *workers, *notDryRun)
+
+	// Stage 4: snapshots whose source disk was just deleted are now
+	// eligible.
+	deleteSnapshots(gcetd.Snapshots, *workers, *notDryRun)
+
+	// Stage 5: networks can only be removed once nothing deleted above
+	// (firewalls, addresses) still references them.
+	deleteNetworks(gcetd.Networks, *workers, *notDryRun)
+}
 
-	imtd := images.NewJanitorMetadata(compute, *project, tooOld, *deleteSingletons, blacklistConfig.Images, *nameDelimiter)
-	intd := instances.NewJanitorMetadata(compute, *project, tooOld, *deleteSingletons, blacklistConfig.Instances, *nameDelimiter)
+// janitorConfig bundles up everything needed to construct the per-provider
+// JanitorMetadata implementations.
+type janitorConfig struct {
+	Provider           images.Provider
+	Project            string
+	AzureResourceGroup string
+	ExpiredBefore      time.Time
+	DeleteSingletons   bool
+	Blacklist          BlacklistConfig
+	NameDelimiter      string
+	// Force, if set, includes GCE instances with deletionProtection
+	// enabled in the candidate list instead of excluding them. It has no
+	// effect for the AWS or Azure providers.
+	Force bool
+}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go deleteImages(imtd, *workers, *notDryRun, &wg)
-	go deleteInstances(intd, *workers, *notDryRun, &wg)
-	wg.Wait()
+// gceResources bundles up the GCE-only resource types that pkg/images and
+// pkg/instances don't cover. They have no AWS or Azure equivalent wired up
+// yet, so newJanitorMetadata only populates this for the GCE provider.
+type gceResources struct {
+	Disks           *disks.JanitorMetadata
+	Snapshots       *snapshots.JanitorMetadata
+	ForwardingRules *forwardingrules.JanitorMetadata
+	Firewalls       *firewalls.JanitorMetadata
+	Addresses       *addresses.JanitorMetadata
+	Networks        *networks.JanitorMetadata
 }
 
-func initClient() (*compute.Service, error) {
+// newJanitorMetadata builds the images and instances JanitorMetadata
+// implementations for the requested cloud provider, along with the
+// additional GCE-only resource types when c.Provider is GCE.
+func newJanitorMetadata(c janitorConfig) (images.JanitorMetadata, instances.JanitorMetadata, *gceResources, error) {
+	switch c.Provider {
+	case images.AWS:
+		cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(c.Project))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		client := ec2.NewFromConfig(cfg)
+
+		imtd := images.NewAWSJanitorMetadata(client, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Images, c.NameDelimiter)
+		intd := instances.NewAWSJanitorMetadata(client, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Instances, c.NameDelimiter)
+		return imtd, intd, nil, nil
+	case images.Azure:
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		imagesClient, err := armcompute.NewImagesClient(c.Project, cred, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		vmClient, err := armcompute.NewVirtualMachinesClient(c.Project, cred, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		imtd := images.NewAzureJanitorMetadata(imagesClient, c.AzureResourceGroup, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Images, c.NameDelimiter)
+		intd := instances.NewAzureJanitorMetadata(vmClient, c.AzureResourceGroup, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Instances, c.NameDelimiter)
+		return imtd, intd, nil, nil
+	default:
+		computeSvc, err := initGCEClient()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		imtd := images.NewGCEJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Rules.Images, c.NameDelimiter)
+		intd := instances.NewGCEJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, c.Blacklist.Rules.Instances, c.NameDelimiter, c.Force)
+
+		gcetd := &gceResources{
+			Disks:           disks.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+			Snapshots:       snapshots.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+			ForwardingRules: forwardingrules.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+			Firewalls:       firewalls.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+			Addresses:       addresses.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+			Networks:        networks.NewJanitorMetadata(computeSvc, c.Project, c.ExpiredBefore, c.DeleteSingletons, nil, c.NameDelimiter),
+		}
+		return imtd, intd, gcetd, nil
+	}
+}
+
+// runEventJanitor runs the janitor as a long-lived controller, reacting to
+// compute.instances.insert audit log events delivered over subscription
+// instead of sweeping the project on a schedule.
+func runEventJanitor(ctx context.Context, s *compute.Service, project, subscription string, ttl time.Duration, dryRun bool) error {
+	client, err := pubsub.NewClient(ctx, project)
+	if err != nil {
+		return fmt.Errorf("main.go: unable to create pubsub client: %w", err)
+	}
+
+	controller := eventjanitor.NewController(s, ttl, dryRun)
+	return controller.Run(ctx, client.Subscription(subscription))
+}
+
+func initGCEClient() (*compute.Service, error) {
 	client, err := google.DefaultClient(oauth2.NoContext,
 		"https://www.googleapis.com/auth/compute")
 	if err != nil {
@@ -129,50 +326,202 @@ func initClient() (*compute.Service, error) {
 	return computeService, nil
 }
 
-func deleteImages(i *images.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
-	err := i.Refresh()
-	i.Blacklist()
+func deleteImages(i images.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if err := i.Refresh(); err != nil {
+		log.Fatalf("Unable to refresh images: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some images")
+	}
 	i.Singletons()
-	i.Expired()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some images")
+	}
 
-	if len(i.Items) == 0 {
+	if i.Len() == 0 {
 		log.Info("No images to delete")
-		wg.Done()
 		return
 	}
 
-	if notDryRun {
-		err = delete.Parallel(workers, i)
-		if err != nil {
-			log.Fatalf("Deletion exited with an error: %s", err)
-		}
+	if err := delete.Parallel(workers, i, !notDryRun, "image"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up images")
+}
+
+func deleteInstances(i instances.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if err := i.Refresh(); err != nil {
+		log.Fatalf("Unable to refresh instances: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some instances")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some instances")
+	}
+
+	if i.Len() == 0 {
+		log.Info("No instances to delete")
+		return
+	}
+
+	if err := delete.Parallel(workers, i, !notDryRun, "instance"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up instances")
+}
+
+func deleteForwardingRules(i *forwardingrules.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+	defer wg.Done()
 
-		log.Info("Successfully cleaned up images")
+	err := i.Refresh()
+	if err != nil {
+		log.Fatalf("Unable to refresh forwarding rules: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some forwarding rules")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some forwarding rules")
 	}
 
-	wg.Done()
+	if i.Len() == 0 {
+		log.Info("No forwarding rules to delete")
+		return
+	}
+
+	if err := delete.Parallel(workers, i, !notDryRun, "forwarding_rule"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up forwarding rules")
 }
 
-func deleteInstances(i *instances.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+func deleteAddresses(i *addresses.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	err := i.Refresh()
-	i.Blacklist()
+	if err != nil {
+		log.Fatalf("Unable to refresh addresses: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some addresses")
+	}
 	i.Singletons()
-	i.Expired()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some addresses")
+	}
 
-	if len(i.Items) == 0 {
-		log.Info("No instances to delete")
-		wg.Done()
+	if i.Len() == 0 {
+		log.Info("No addresses to delete")
 		return
 	}
 
-	if notDryRun {
-		err = delete.Parallel(workers, i)
-		if err != nil {
-			log.Fatalf("Deletion exited with an error: %s", err)
-		}
+	if err := delete.Parallel(workers, i, !notDryRun, "address"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up addresses")
+}
+
+func deleteFirewalls(i *firewalls.JanitorMetadata, workers int, notDryRun bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	err := i.Refresh()
+	if err != nil {
+		log.Fatalf("Unable to refresh firewall rules: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some firewall rules")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some firewall rules")
+	}
 
-		log.Info("Successfully cleaned up instances")
+	if i.Len() == 0 {
+		log.Info("No firewall rules to delete")
+		return
 	}
 
-	wg.Done()
+	if err := delete.Parallel(workers, i, !notDryRun, "firewall"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up firewall rules")
+}
+
+func deleteDisks(i *disks.JanitorMetadata, workers int, notDryRun bool) {
+	err := i.Refresh()
+	if err != nil {
+		log.Fatalf("Unable to refresh disks: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some disks")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some disks")
+	}
+
+	if i.Len() == 0 {
+		log.Info("No disks to delete")
+		return
+	}
+
+	if err := delete.Parallel(workers, i, !notDryRun, "disk"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up disks")
+}
+
+func deleteSnapshots(i *snapshots.JanitorMetadata, workers int, notDryRun bool) {
+	err := i.Refresh()
+	if err != nil {
+		log.Fatalf("Unable to refresh snapshots: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some snapshots")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some snapshots")
+	}
+
+	if i.Len() == 0 {
+		log.Info("No snapshots to delete")
+		return
+	}
+
+	if err := delete.Parallel(workers, i, !notDryRun, "snapshot"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up snapshots")
+}
+
+func deleteNetworks(i *networks.JanitorMetadata, workers int, notDryRun bool) {
+	err := i.Refresh()
+	if err != nil {
+		log.Fatalf("Unable to refresh networks: %s", err)
+	}
+	if err := i.Blacklist(); err != nil {
+		log.WithField("error", err).Error("Unable to evaluate blacklist rules for some networks")
+	}
+	i.Singletons()
+	if err := i.Expired(); err != nil {
+		log.WithField("error", err).Error("Unable to parse expiry for some networks")
+	}
+
+	if i.Len() == 0 {
+		log.Info("No networks to delete")
+		return
+	}
+
+	if err := delete.Parallel(workers, i, !notDryRun, "network"); err != nil {
+		log.Fatalf("Deletion exited with an error: %s", err)
+	}
+	log.Info("Successfully cleaned up networks")
 }
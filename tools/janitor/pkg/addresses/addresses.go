@@ -0,0 +1,316 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package addresses implements the delete.JanitorMetadata interface for GCE
+// regional static external IP addresses. It should be run after
+// pkg/forwardingrules so that addresses freed by a just-deleted forwarding
+// rule are picked up by the same janitor run.
+package addresses
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue an address deletion call and
+// watch the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Region    string
+	Address   *compute.Address
+	Call      *compute.AddressesDeleteCall
+}
+
+// Name returns the name of the address being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Address.Name
+}
+
+// Project returns the project the address belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns the address's region, since addresses are a regional rather
+// than zonal resource.
+func (d *DeleteMetadata) Zone() string {
+	return d.Region
+}
+
+// Do executes the AddressesDeleteCall for the given address.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"address": d.Address.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API address service and
+// implements the delete.JanitorMetadata interface so addresses can be
+// deleted via the deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Address
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of addresses still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with every static
+// address that isn't currently in use.
+func (i *JanitorMetadata) Refresh() error {
+	regions, err := i.Service.Regions.List(i.Project).Do()
+	if err != nil {
+		return fmt.Errorf("addresses: unable to list regions: %w", err)
+	}
+
+	var itl []*compute.Address
+	for _, region := range regions.Items {
+		alc := i.Service.Addresses.List(i.Project, region.Name)
+		for {
+			al, err := alc.Do()
+			if err != nil {
+				return fmt.Errorf("addresses: unable to list addresses in region %s: %w", region.Name, err)
+			}
+
+			for _, a := range al.Items {
+				metrics.ResourcesScanned.WithLabelValues("address", i.Project, region.Name).Inc()
+
+				if a.Status == "IN_USE" {
+					log.WithFields(log.Fields{
+						"name":  a.Name,
+						"users": a.Users,
+					}).Debug("Address is in use, skipping")
+					continue
+				}
+				log.WithFields(log.Fields{
+					"name":               a.Name,
+					"creation_timestamp": a.CreationTimestamp,
+					"region":             region.Name,
+					"project":            i.Project,
+				}).Info("Found address")
+				itl = append(itl, a)
+			}
+
+			if al.NextPageToken == "" {
+				break
+			}
+			alc = alc.PageToken(al.NextPageToken)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// addressLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given address.
+func addressLabels(a *compute.Address) map[string]string {
+	labels := make(map[string]string, len(a.Labels)+2)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["region"] = utils.GetResourceNameFromURL(a.Region)
+
+	if stamp, err := utils.ParseCreationTimestamp(a.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every address's labels, dropping any
+// address a rule decides to drop. An address whose rules fail to evaluate
+// is left out of the candidate list and its error is recorded rather than
+// aborting the whole call, so one bad address doesn't block every other
+// address's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Address
+	var errs []string
+	for _, a := range i.Items {
+		labels, keep, err := relabel.Apply(addressLabels(a), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"address": a.Name,
+				"error":   err,
+			}).Error("Unable to evaluate relabel rules, skipping address")
+			errs = append(errs, fmt.Sprintf("%s: %s", a.Name, err))
+			continue
+		}
+
+		a.Labels = labels
+		if keep {
+			bi = append(bi, a)
+		} else {
+			log.WithFields(log.Fields{
+				"address": a.Name,
+				"labels":  labels,
+			}).Info("Address is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("addresses: unable to evaluate relabel rules for %d address(es): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes addresses that by name are singleton. It does nothing
+// when DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Address{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, a := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(a.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(a.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{a.Name, true}
+			log.WithFields(log.Fields{
+				"name":               a.Name,
+				"creation_timestamp": a.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Address excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(a.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{a.Name, false}
+			il = append(il, a)
+			log.WithFields(log.Fields{
+				"name":               a.Name,
+				"creation_timestamp": a.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Address eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Address is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes addresses that are not past the ExpiredBefore time. An address
+// whose creation timestamp can't be parsed is dropped from the candidate
+// list and its error is recorded rather than aborting the whole call.
+func (i *JanitorMetadata) Expired() error {
+	al := []*compute.Address{}
+	var errs []string
+
+	for _, a := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(a.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": a.CreationTimestamp,
+				"address":            a.Name,
+			}).Error("Failed to parse timestamp, skipping address")
+			errs = append(errs, fmt.Sprintf("%s: %s", a.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"address":            a.Name,
+				"creation_timestamp": a.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Address is older than expired_by date")
+			al = append(al, a)
+		} else {
+			log.WithFields(log.Fields{
+				"address":            a.Name,
+				"creation_timestamp": a.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Address is newer than expired_by date")
+		}
+	}
+
+	i.Items = al
+	if len(errs) > 0 {
+		return fmt.Errorf("addresses: unable to parse creation timestamp for %d address(es): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of addresses they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, a := range i.Items {
+		region := utils.GetResourceNameFromURL(a.Region)
+		log.WithFields(log.Fields{
+			"name":    a.Name,
+			"project": i.Project,
+			"region":  region,
+		}).Debug("Generating deletion call for address")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Region:    region,
+			Address:   a,
+			Call:      i.Service.Addresses.Delete(i.Project, region, a.Name),
+		}
+	}
+	close(d)
+}
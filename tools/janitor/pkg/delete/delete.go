@@ -15,50 +15,74 @@
 package delete
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
-	compute "google.golang.org/api/compute/v1"
-	"google.golang.org/api/googleapi"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
 )
 
-// ResourceDeleter provides an interface that is used by the individual
-// deletion workers.
+// Operation represents an in-flight deletion against any supported cloud
+// provider. Wait blocks until the underlying deletion completes or ctx is
+// cancelled.
+type Operation interface {
+	Wait(ctx context.Context) error
+}
+
+// ResourceDeleter provides a provider-agnostic interface that is used by the
+// individual deletion workers. Each cloud provider's resource package
+// (pkg/instances, pkg/images, ...) implements this once per resource so that
+// Parallel never needs to know which cloud it's talking to.
 type ResourceDeleter interface {
+	// Name identifies the resource being deleted. It's used only for
+	// logging.
+	Name() string
+	// Project identifies the project the resource belongs to, for
+	// metric labeling. It returns "" for providers that don't carry a
+	// project-like scope on the deleted resource.
 	Project() string
-	Service() *compute.Service
-	Do(...googleapi.CallOption) (*compute.Operation, error)
+	// Zone identifies the zone the resource lives in, for metric
+	// labeling. Regional resources return their region here instead;
+	// global or unscoped resources return "".
 	Zone() string
-	ZoneOperationsService() *compute.ZoneOperationsService
-	RegionOperationsService() *compute.RegionOperationsService
-	GlobalOperationsService() *compute.GlobalOperationsService
+	// Do issues the deletion call and returns an Operation that can be
+	// waited on until the deletion completes.
+	Do() (Operation, error)
 }
 
-// ResourceGetter provides an interface used to allow for querying for operation's
-// status to be mostly generic.
-type ResourceGetter interface {
-	Do(s ...googleapi.CallOption) (*compute.Operation, error)
-}
-
-// JanitoryMetadata provides an interface to be used by each resource that
+// JanitorMetadata provides an interface to be used by each resource that
 // will be deleted by the deletion engine.
 type JanitorMetadata interface {
 	Delete(chan<- ResourceDeleter)
 }
 
+// plan is the structured record a dry run emits in place of actually
+// issuing a ResourceDeleter's delete call.
+type plan struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+}
+
 // Parallel issues a parallel delete by starting an engine comprised of workers
-// capable of deleting resources.
-func Parallel(workers int, i JanitorMetadata) error {
+// capable of deleting resources. When dryRun is true, no delete calls are
+// issued; instead each worker logs the call it would have made as a
+// structured plan. resourceType, together with each ResourceDeleter's own
+// Project() and Zone(), labels the janitor_resources_deleted_total,
+// janitor_delete_errors_total, and janitor_operation_duration_seconds
+// metrics this run reports. A single resource's delete or operation-wait
+// failure is logged and counted rather than aborting the run, so one
+// protected or quota-limited resource doesn't take down the whole sweep.
+func Parallel(workers int, i JanitorMetadata, dryRun bool, resourceType string) error {
 	r := make(chan ResourceDeleter, workers)
 	var wg sync.WaitGroup
 
-	for i := 0; i < workers; i++ {
+	for n := 0; n < workers; n++ {
 		wg.Add(1)
-		go deleteWorker(fmt.Sprintf("instance-worker-%d", i), r, &wg)
+		go deleteWorker(fmt.Sprintf("resource-worker-%d", n), r, dryRun, resourceType, &wg)
 	}
 
 	i.Delete(r)
@@ -67,50 +91,45 @@ func Parallel(workers int, i JanitorMetadata) error {
 	return nil
 }
 
-func deleteWorker(id string, resourceDeleteCalls <-chan ResourceDeleter, wg *sync.WaitGroup) {
+func deleteWorker(id string, resourceDeleteCalls <-chan ResourceDeleter, dryRun bool, resourceType string, wg *sync.WaitGroup) {
 	idLog := log.WithFields(log.Fields{
 		"worker": id,
 	})
 	idLog.Info("Starting delete worker")
 	defer wg.Done()
 	for call := range resourceDeleteCalls {
-		deleteOperation, err := call.Do()
-		if err != nil {
-			idLog.WithField("error", err).Fatal("Unable to issue delete call")
-		}
-		var queryDeleteOperation ResourceGetter
-		if deleteOperation.Zone != "" {
-			queryDeleteOperation = call.ZoneOperationsService().Get(call.Project(), utils.GetResourceNameFromURL(deleteOperation.Zone), deleteOperation.Name)
-		} else if deleteOperation.Region != "" {
-			queryDeleteOperation = call.RegionOperationsService().Get(call.Project(), utils.GetResourceNameFromURL(deleteOperation.Region), deleteOperation.Name)
-		} else {
-			queryDeleteOperation = call.GlobalOperationsService().Get(call.Project(), deleteOperation.Name)
-		}
-		for {
-			toSleep, _ := time.ParseDuration("3s")
-			time.Sleep(toSleep)
-			deleteOperation, err = queryDeleteOperation.Do()
+		resourceLog := idLog.WithField("resource", call.Name())
+		project, zone := call.Project(), call.Zone()
+
+		if dryRun {
+			p, err := json.Marshal(plan{Action: "delete", Resource: call.Name()})
 			if err != nil {
-				idLog.WithFields(log.Fields{
-					"operation_id": deleteOperation.Name,
-					"error":        err,
-				}).Fatal("Unable to get operation")
+				resourceLog.WithField("error", err).Error("Unable to marshal dry-run plan")
+				continue
 			}
+			fmt.Println(string(p))
+			resourceLog.Info("Dry run: skipping delete")
+			continue
+		}
 
-			if deleteOperation.Status == "DONE" {
-				break
-			} else {
-				idLog.WithFields(log.Fields{
-					"operation_id": deleteOperation.Name,
-					"start_time":   deleteOperation.StartTime,
-					"status":       deleteOperation.Status,
-					"resource":     deleteOperation.TargetLink,
-				}).Info("Deleting resource")
-			}
+		start := time.Now()
+
+		op, err := call.Do()
+		if err != nil {
+			resourceLog.WithField("error", err).Error("Unable to issue delete call")
+			metrics.DeleteErrors.WithLabelValues(resourceType, project, zone).Inc()
+			continue
+		}
+
+		if err := op.Wait(context.Background()); err != nil {
+			resourceLog.WithField("error", err).Error("Unable to wait for delete operation")
+			metrics.DeleteErrors.WithLabelValues(resourceType, project, zone).Inc()
+			continue
 		}
-		idLog.WithFields(log.Fields{
-			"resource": deleteOperation.TargetLink,
-		}).Info("Deleted resource")
+
+		metrics.OperationDuration.WithLabelValues(resourceType, project, zone).Observe(time.Since(start).Seconds())
+		metrics.ResourcesDeleted.WithLabelValues(resourceType, project, zone).Inc()
+		resourceLog.Info("Deleted resource")
 	}
 	idLog.Info("Stopping worker")
 }
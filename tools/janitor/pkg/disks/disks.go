@@ -0,0 +1,311 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package disks implements the delete.JanitorMetadata interface for GCE
+// zonal persistent disks. It should be run after pkg/instances has deleted
+// its candidates so that disks orphaned by a just-deleted instance are
+// picked up by the same janitor run.
+package disks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a disk deletion call and watch
+// the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Disk      *compute.Disk
+	Call      *compute.DisksDeleteCall
+}
+
+// Name returns the name of the disk being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Disk.Name
+}
+
+// Project returns the project the disk belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns the zone the disk lives in.
+func (d *DeleteMetadata) Zone() string {
+	return utils.GetResourceNameFromURL(d.Disk.Zone)
+}
+
+// Do executes the DisksDeleteCall for the given disk.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"disk": d.Disk.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API disk service and implements
+// the delete.JanitorMetadata interface so disks can be deleted via the
+// deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Disk
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of disks still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with every disk that
+// isn't currently attached to an instance.
+func (i *JanitorMetadata) Refresh() error {
+	zones, err := i.Service.Zones.List(i.Project).Do()
+	if err != nil {
+		return fmt.Errorf("disks: unable to list zones: %w", err)
+	}
+
+	var itl []*compute.Disk
+	for _, zone := range zones.Items {
+		dlc := i.Service.Disks.List(i.Project, zone.Name)
+		for {
+			dl, err := dlc.Do()
+			if err != nil {
+				return fmt.Errorf("disks: unable to list disks in zone %s: %w", zone.Name, err)
+			}
+
+			for _, d := range dl.Items {
+				metrics.ResourcesScanned.WithLabelValues("disk", i.Project, zone.Name).Inc()
+
+				if len(d.Users) > 0 {
+					log.WithFields(log.Fields{
+						"name":  d.Name,
+						"users": d.Users,
+					}).Debug("Disk is attached, skipping")
+					continue
+				}
+				log.WithFields(log.Fields{
+					"name":               d.Name,
+					"creation_timestamp": d.CreationTimestamp,
+					"zone":               zone.Name,
+					"project":            i.Project,
+				}).Info("Found disk")
+				itl = append(itl, d)
+			}
+
+			if dl.NextPageToken == "" {
+				break
+			}
+			dlc = dlc.PageToken(dl.NextPageToken)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// diskLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given disk.
+func diskLabels(d *compute.Disk) map[string]string {
+	labels := make(map[string]string, len(d.Labels)+2)
+	for k, v := range d.Labels {
+		labels[k] = v
+	}
+	labels["zone"] = utils.GetResourceNameFromURL(d.Zone)
+
+	if stamp, err := utils.ParseCreationTimestamp(d.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every disk's labels, dropping any disk a
+// rule decides to drop. A disk whose rules fail to evaluate is left out of
+// the candidate list and its error is recorded rather than aborting the
+// whole call, so one bad disk doesn't block every other disk's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Disk
+	var errs []string
+	for _, d := range i.Items {
+		labels, keep, err := relabel.Apply(diskLabels(d), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"disk":  d.Name,
+				"error": err,
+			}).Error("Unable to evaluate relabel rules, skipping disk")
+			errs = append(errs, fmt.Sprintf("%s: %s", d.Name, err))
+			continue
+		}
+
+		d.Labels = labels
+		if keep {
+			bi = append(bi, d)
+		} else {
+			log.WithFields(log.Fields{
+				"disk":   d.Name,
+				"labels": labels,
+			}).Info("Disk is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("disks: unable to evaluate relabel rules for %d disk(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes disks that by name are singleton. It does nothing when
+// DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Disk{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, d := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(d.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(d.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{d.Name, true}
+			log.WithFields(log.Fields{
+				"name":               d.Name,
+				"creation_timestamp": d.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Disk excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(d.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{d.Name, false}
+			il = append(il, d)
+			log.WithFields(log.Fields{
+				"name":               d.Name,
+				"creation_timestamp": d.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Disk eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Disk is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes disks that are not past the ExpiredBefore time. A disk whose
+// creation timestamp can't be parsed is dropped from the candidate list
+// and its error is recorded rather than aborting the whole call.
+func (i *JanitorMetadata) Expired() error {
+	dl := []*compute.Disk{}
+	var errs []string
+
+	for _, d := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(d.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": d.CreationTimestamp,
+				"disk":               d.Name,
+			}).Error("Failed to parse timestamp, skipping disk")
+			errs = append(errs, fmt.Sprintf("%s: %s", d.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"disk":               d.Name,
+				"creation_timestamp": d.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Disk is older than expired_by date")
+			dl = append(dl, d)
+		} else {
+			log.WithFields(log.Fields{
+				"disk":               d.Name,
+				"creation_timestamp": d.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Disk is newer than expired_by date")
+		}
+	}
+
+	i.Items = dl
+	if len(errs) > 0 {
+		return fmt.Errorf("disks: unable to parse creation timestamp for %d disk(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of disks they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, disk := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    disk.Name,
+			"project": i.Project,
+			"zone":    utils.GetResourceNameFromURL(disk.Zone),
+		}).Debug("Generating deletion call for disk")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Disk:      disk,
+			Call:      i.Service.Disks.Delete(i.Project, utils.GetResourceNameFromURL(disk.Zone), disk.Name),
+		}
+	}
+	close(d)
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventjanitor turns the batch-oriented janitor into a long-running
+// controller. Instead of waiting for the next scheduled Zones.List sweep, it
+// subscribes to a Cloud Pub/Sub topic fed by a Cloud Audit Logs sink and
+// reacts to compute.instances.insert events by scheduling a deletion for the
+// moment the new instance crosses its expiry.
+package eventjanitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	log "github.com/sirupsen/logrus"
+	compute "google.golang.org/api/compute/v1"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+)
+
+// insertMethod is the Compute Engine audit log method name recorded when an
+// instance is created.
+const insertMethod = "v1.compute.instances.insert"
+
+// auditLogEntry is the subset of a Cloud Audit Log entry, as delivered to
+// Pub/Sub by a logging sink, that this package needs.
+type auditLogEntry struct {
+	ProtoPayload struct {
+		MethodName   string `json:"methodName"`
+		ResourceName string `json:"resourceName"`
+	} `json:"protoPayload"`
+	Resource struct {
+		Labels struct {
+			ProjectID string `json:"project_id"`
+			Zone      string `json:"zone"`
+		} `json:"labels"`
+	} `json:"resource"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Controller subscribes to a Pub/Sub subscription fed by a Compute Engine
+// audit log sink and schedules a deletion for every newly created instance
+// once it crosses TTL.
+type Controller struct {
+	Service *compute.Service
+	TTL     time.Duration
+	DryRun  bool
+}
+
+// NewController creates a new instance of Controller.
+func NewController(s *compute.Service, ttl time.Duration, dryRun bool) *Controller {
+	return &Controller{Service: s, TTL: ttl, DryRun: dryRun}
+}
+
+// Run pulls messages from sub until ctx is cancelled or an unrecoverable
+// error occurs, scheduling a delete for every compute.instances.insert
+// event it sees.
+func (c *Controller) Run(ctx context.Context, sub *pubsub.Subscription) error {
+	return sub.Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
+		entry, err := parseAuditLogEntry(msg.Data)
+		if err != nil {
+			log.WithField("error", err).Warn("Unable to parse audit log entry, acking anyway")
+			msg.Ack()
+			return
+		}
+		msg.Ack()
+
+		if entry.ProtoPayload.MethodName != insertMethod {
+			return
+		}
+
+		name := utils.GetResourceNameFromURL(entry.ProtoPayload.ResourceName)
+		project := entry.Resource.Labels.ProjectID
+		zone := entry.Resource.Labels.Zone
+		expiresAt := entry.Timestamp.Add(c.TTL)
+
+		log.WithFields(log.Fields{
+			"instance":   name,
+			"project":    project,
+			"zone":       zone,
+			"expires_at": expiresAt,
+		}).Info("Scheduling deletion for newly created instance")
+
+		c.schedule(name, project, zone, expiresAt)
+	})
+}
+
+// schedule waits until expiresAt and then deletes the named instance,
+// unless the controller is running in dry-run mode.
+func (c *Controller) schedule(name, project, zone string, expiresAt time.Time) {
+	go func() {
+		if d := time.Until(expiresAt); d > 0 {
+			time.Sleep(d)
+		}
+
+		instanceLog := log.WithFields(log.Fields{
+			"instance": name,
+			"project":  project,
+			"zone":     zone,
+		})
+
+		if c.DryRun {
+			instanceLog.Info("Dry run: instance has expired and would be deleted")
+			return
+		}
+
+		if _, err := c.Service.Instances.Delete(project, zone, name).Do(); err != nil {
+			instanceLog.WithField("error", err).Error("Unable to delete expired instance")
+			return
+		}
+		instanceLog.Info("Deleted expired instance")
+	}()
+}
+
+func parseAuditLogEntry(data []byte) (*auditLogEntry, error) {
+	var entry auditLogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("eventjanitor: unable to unmarshal audit log entry: %w", err)
+	}
+	return &entry, nil
+}
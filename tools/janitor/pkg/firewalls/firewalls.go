@@ -0,0 +1,293 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package firewalls implements the delete.JanitorMetadata interface for GCE
+// firewall rules. Firewall rules reference a network, so this package
+// should be run before pkg/networks deletes the network it points to.
+package firewalls
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a firewall deletion call and
+// watch the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Firewall  *compute.Firewall
+	Call      *compute.FirewallsDeleteCall
+}
+
+// Name returns the name of the firewall rule being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Firewall.Name
+}
+
+// Project returns the project the firewall rule belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns "" since firewall rules are a global resource.
+func (d *DeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do executes the FirewallsDeleteCall for the given firewall rule.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"firewall": d.Firewall.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API firewall service and
+// implements the delete.JanitorMetadata interface so firewall rules can be
+// deleted via the deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Firewall
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of firewall rules still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with all current
+// firewall rules.
+func (i *JanitorMetadata) Refresh() error {
+	flc := i.Service.Firewalls.List(i.Project)
+	var itl []*compute.Firewall
+	for {
+		fl, err := flc.Do()
+		if err != nil {
+			return fmt.Errorf("firewalls: unable to list firewall rules: %w", err)
+		}
+
+		for _, f := range fl.Items {
+			metrics.ResourcesScanned.WithLabelValues("firewall", i.Project, "").Inc()
+
+			log.WithFields(log.Fields{
+				"name":               f.Name,
+				"creation_timestamp": f.CreationTimestamp,
+				"network":            f.Network,
+				"project":            i.Project,
+			}).Info("Found firewall rule")
+			itl = append(itl, f)
+		}
+
+		if fl.NextPageToken == "" {
+			break
+		}
+		flc = flc.PageToken(fl.NextPageToken)
+	}
+	i.Items = itl
+	return nil
+}
+
+// firewallLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given firewall rule.
+func firewallLabels(f *compute.Firewall) map[string]string {
+	labels := make(map[string]string, 2)
+	labels["network"] = utils.GetResourceNameFromURL(f.Network)
+
+	if stamp, err := utils.ParseCreationTimestamp(f.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every firewall rule's labels, dropping
+// any firewall rule a rule decides to drop. A firewall rule whose rules
+// fail to evaluate is left out of the candidate list and its error is
+// recorded rather than aborting the whole call, so one bad firewall rule
+// doesn't block every other firewall rule's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Firewall
+	var errs []string
+	for _, f := range i.Items {
+		labels, keep, err := relabel.Apply(firewallLabels(f), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"firewall": f.Name,
+				"error":    err,
+			}).Error("Unable to evaluate relabel rules, skipping firewall rule")
+			errs = append(errs, fmt.Sprintf("%s: %s", f.Name, err))
+			continue
+		}
+
+		if keep {
+			bi = append(bi, f)
+		} else {
+			log.WithFields(log.Fields{
+				"firewall": f.Name,
+				"labels":   labels,
+			}).Info("Firewall rule is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("firewalls: unable to evaluate relabel rules for %d firewall rule(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes firewall rules that by name are singleton. It does
+// nothing when DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Firewall{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, f := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(f.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(f.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{f.Name, true}
+			log.WithFields(log.Fields{
+				"name":               f.Name,
+				"creation_timestamp": f.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Firewall rule excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(f.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{f.Name, false}
+			il = append(il, f)
+			log.WithFields(log.Fields{
+				"name":               f.Name,
+				"creation_timestamp": f.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Firewall rule eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Firewall rule is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes firewall rules that are not past the ExpiredBefore time. A
+// firewall rule whose creation timestamp can't be parsed is dropped from
+// the candidate list and its error is recorded rather than aborting the
+// whole call.
+func (i *JanitorMetadata) Expired() error {
+	fl := []*compute.Firewall{}
+	var errs []string
+
+	for _, f := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(f.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": f.CreationTimestamp,
+				"firewall":           f.Name,
+			}).Error("Failed to parse timestamp, skipping firewall rule")
+			errs = append(errs, fmt.Sprintf("%s: %s", f.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"firewall":           f.Name,
+				"creation_timestamp": f.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Firewall rule is older than expired_by date")
+			fl = append(fl, f)
+		} else {
+			log.WithFields(log.Fields{
+				"firewall":           f.Name,
+				"creation_timestamp": f.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Firewall rule is newer than expired_by date")
+		}
+	}
+
+	i.Items = fl
+	if len(errs) > 0 {
+		return fmt.Errorf("firewalls: unable to parse creation timestamp for %d firewall rule(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of firewall rules they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, f := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    f.Name,
+			"project": i.Project,
+		}).Debug("Generating deletion call for firewall rule")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Firewall:  f,
+			Call:      i.Service.Firewalls.Delete(i.Project, f.Name),
+		}
+	}
+	close(d)
+}
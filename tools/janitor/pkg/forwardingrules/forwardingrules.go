@@ -0,0 +1,310 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forwardingrules implements the delete.JanitorMetadata interface
+// for GCE regional forwarding rules. It should be run before pkg/addresses
+// so that static addresses held by a forwarding rule are freed up before
+// the address itself is considered for deletion.
+package forwardingrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a forwarding rule deletion call
+// and watch the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Region    string
+	Rule      *compute.ForwardingRule
+	Call      *compute.ForwardingRulesDeleteCall
+}
+
+// Name returns the name of the forwarding rule being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Rule.Name
+}
+
+// Project returns the project the forwarding rule belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns the forwarding rule's region, since forwarding rules are a
+// regional rather than zonal resource.
+func (d *DeleteMetadata) Zone() string {
+	return d.Region
+}
+
+// Do executes the ForwardingRulesDeleteCall for the given forwarding rule.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"forwarding_rule": d.Rule.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API forwarding rule service and
+// implements the delete.JanitorMetadata interface so forwarding rules can
+// be deleted via the deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.ForwardingRule
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of forwarding rules still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with all current
+// regional forwarding rules.
+func (i *JanitorMetadata) Refresh() error {
+	regions, err := i.Service.Regions.List(i.Project).Do()
+	if err != nil {
+		return fmt.Errorf("forwardingrules: unable to list regions: %w", err)
+	}
+
+	var itl []*compute.ForwardingRule
+	for _, region := range regions.Items {
+		frc := i.Service.ForwardingRules.List(i.Project, region.Name)
+		for {
+			fl, err := frc.Do()
+			if err != nil {
+				return fmt.Errorf("forwardingrules: unable to list forwarding rules in region %s: %w", region.Name, err)
+			}
+
+			for _, fr := range fl.Items {
+				metrics.ResourcesScanned.WithLabelValues("forwarding_rule", i.Project, region.Name).Inc()
+
+				log.WithFields(log.Fields{
+					"name":               fr.Name,
+					"creation_timestamp": fr.CreationTimestamp,
+					"region":             region.Name,
+					"project":            i.Project,
+				}).Info("Found forwarding rule")
+				itl = append(itl, fr)
+			}
+
+			if fl.NextPageToken == "" {
+				break
+			}
+			frc = frc.PageToken(fl.NextPageToken)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// forwardingRuleLabels builds the synthetic label set a relabel.Config rule
+// can select on for a given forwarding rule.
+func forwardingRuleLabels(fr *compute.ForwardingRule) map[string]string {
+	labels := make(map[string]string, len(fr.Labels)+2)
+	for k, v := range fr.Labels {
+		labels[k] = v
+	}
+	labels["region"] = utils.GetResourceNameFromURL(fr.Region)
+
+	if stamp, err := utils.ParseCreationTimestamp(fr.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every forwarding rule's labels, dropping
+// any forwarding rule a rule decides to drop. A forwarding rule whose rules
+// fail to evaluate is left out of the candidate list and its error is
+// recorded rather than aborting the whole call, so one bad forwarding rule
+// doesn't block every other forwarding rule's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.ForwardingRule
+	var errs []string
+	for _, fr := range i.Items {
+		labels, keep, err := relabel.Apply(forwardingRuleLabels(fr), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"forwarding_rule": fr.Name,
+				"error":           err,
+			}).Error("Unable to evaluate relabel rules, skipping forwarding rule")
+			errs = append(errs, fmt.Sprintf("%s: %s", fr.Name, err))
+			continue
+		}
+
+		fr.Labels = labels
+		if keep {
+			bi = append(bi, fr)
+		} else {
+			log.WithFields(log.Fields{
+				"forwarding_rule": fr.Name,
+				"labels":          labels,
+			}).Info("Forwarding rule is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("forwardingrules: unable to evaluate relabel rules for %d forwarding rule(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes forwarding rules that by name are singleton. It does
+// nothing when DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.ForwardingRule{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, fr := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(fr.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(fr.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{fr.Name, true}
+			log.WithFields(log.Fields{
+				"name":               fr.Name,
+				"creation_timestamp": fr.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Forwarding rule excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(fr.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{fr.Name, false}
+			il = append(il, fr)
+			log.WithFields(log.Fields{
+				"name":               fr.Name,
+				"creation_timestamp": fr.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Forwarding rule eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Forwarding rule is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes forwarding rules that are not past the ExpiredBefore time. A
+// forwarding rule whose creation timestamp can't be parsed is dropped from
+// the candidate list and its error is recorded rather than aborting the
+// whole call.
+func (i *JanitorMetadata) Expired() error {
+	frl := []*compute.ForwardingRule{}
+	var errs []string
+
+	for _, fr := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(fr.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": fr.CreationTimestamp,
+				"forwarding_rule":    fr.Name,
+			}).Error("Failed to parse timestamp, skipping forwarding rule")
+			errs = append(errs, fmt.Sprintf("%s: %s", fr.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"forwarding_rule":    fr.Name,
+				"creation_timestamp": fr.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Forwarding rule is older than expired_by date")
+			frl = append(frl, fr)
+		} else {
+			log.WithFields(log.Fields{
+				"forwarding_rule":    fr.Name,
+				"creation_timestamp": fr.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Forwarding rule is newer than expired_by date")
+		}
+	}
+
+	i.Items = frl
+	if len(errs) > 0 {
+		return fmt.Errorf("forwardingrules: unable to parse creation timestamp for %d forwarding rule(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of forwarding rules they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, fr := range i.Items {
+		region := utils.GetResourceNameFromURL(fr.Region)
+		log.WithFields(log.Fields{
+			"name":    fr.Name,
+			"project": i.Project,
+			"region":  region,
+		}).Debug("Generating deletion call for forwarding rule")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Region:    region,
+			Rule:      fr,
+			Call:      i.Service.ForwardingRules.Delete(i.Project, region, fr.Name),
+		}
+	}
+	close(d)
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gce holds logic shared by every GCE resource janitor
+// implementation (pkg/images, pkg/instances, ...).
+package gce
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Poll backoff bounds used while waiting on an operation. The interval
+// starts at initialPollBackoff and doubles (with jitter) after every
+// retryable error, capping at maxPollBackoff.
+const (
+	initialPollBackoff = 1 * time.Second
+	maxPollBackoff     = 30 * time.Second
+	backoffMultiplier  = 2.0
+)
+
+// retryableStatusCodes are the googleapi.Error codes worth retrying: rate
+// limiting and transient server errors.
+var retryableStatusCodes = map[int]bool{
+	429: true,
+	500: true,
+	502: true,
+	503: true,
+}
+
+// isRetryable reports whether err is a googleapi.Error with a status code
+// worth retrying.
+func isRetryable(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	return retryableStatusCodes[gerr.Code]
+}
+
+// nextBackoff doubles d, caps it at maxPollBackoff, and applies up to 50%
+// jitter so that many operations polling in lockstep don't all retry at
+// once.
+func nextBackoff(d time.Duration) time.Duration {
+	d = time.Duration(float64(d) * backoffMultiplier)
+	if d > maxPollBackoff {
+		d = maxPollBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(d) / 2))
+	return d/2 + jitter
+}
+
+// operationGetter is satisfied by the Get() call of any of the Compute
+// Engine zone, region, or global operations services.
+type operationGetter interface {
+	Do(opts ...googleapi.CallOption) (*compute.Operation, error)
+}
+
+// Operation wraps a Compute Engine zone, region, or global operation so it
+// satisfies the delete.Operation interface shared across cloud providers.
+type Operation struct {
+	Service   *compute.Service
+	Project   string
+	Operation *compute.Operation
+}
+
+// Wait polls the wrapped operation until it reaches the DONE status or ctx
+// is cancelled. Polling backs off exponentially, with jitter, whenever the
+// Compute API returns a rate-limiting or transient server error.
+func (o *Operation) Wait(ctx context.Context) error {
+	getter := o.getter()
+	op := o.Operation
+	backoff := initialPollBackoff
+
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		newOp, err := getter.Do()
+		if err != nil {
+			if isRetryable(err) {
+				backoff = nextBackoff(backoff)
+				log.WithFields(log.Fields{
+					"operation_id": op.Name,
+					"error":        err,
+					"backoff":      backoff,
+				}).Warn("Retryable error polling operation, backing off")
+				continue
+			}
+			return fmt.Errorf("gce: unable to get operation %s: %w", o.Operation.Name, err)
+		}
+		op = newOp
+		backoff = initialPollBackoff
+
+		log.WithFields(log.Fields{
+			"operation_id": op.Name,
+			"start_time":   op.StartTime,
+			"status":       op.Status,
+			"resource":     op.TargetLink,
+		}).Info("Waiting for operation")
+	}
+
+	return nil
+}
+
+func (o *Operation) getter() operationGetter {
+	switch {
+	case o.Operation.Zone != "":
+		return o.Service.ZoneOperations.Get(o.Project, utils.GetResourceNameFromURL(o.Operation.Zone), o.Operation.Name)
+	case o.Operation.Region != "":
+		return o.Service.RegionOperations.Get(o.Project, utils.GetResourceNameFromURL(o.Operation.Region), o.Operation.Name)
+	default:
+		return o.Service.GlobalOperations.Get(o.Project, o.Operation.Name)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+)
+
+// awsOperation is a no-op operation: DeregisterImage and DeleteSnapshot are
+// synchronous EC2 API calls, so there's nothing left to poll once Do
+// returns.
+type awsOperation struct{}
+
+// Wait always returns immediately since the underlying EC2 calls are
+// synchronous.
+func (o *awsOperation) Wait(ctx context.Context) error {
+	return nil
+}
+
+// AWSDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to deregister an AMI and delete its
+// backing snapshots.
+type AWSDeleteMetadata struct {
+	Client *ec2.Client
+	Image  ec2types.Image
+}
+
+// Name returns the name of the AMI being deleted.
+func (id *AWSDeleteMetadata) Name() string {
+	return imageName(id.Image)
+}
+
+// Project returns "" since AWS AMIs aren't scoped to a project-like
+// identifier in this package.
+func (id *AWSDeleteMetadata) Project() string {
+	return ""
+}
+
+// Zone returns "" since AWS AMIs aren't scoped to a region here.
+func (id *AWSDeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do deregisters the AMI and deletes the EBS snapshots that back it.
+func (id *AWSDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"image": id.Name(),
+	}).Info("Issuing delete")
+
+	ctx := context.Background()
+	if _, err := id.Client.DeregisterImage(ctx, &ec2.DeregisterImageInput{
+		ImageId: id.Image.ImageId,
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, bdm := range id.Image.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+		if _, err := id.Client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: bdm.Ebs.SnapshotId,
+		}); err != nil {
+			return nil, fmt.Errorf("images: unable to delete snapshot %s backing %s: %w", *bdm.Ebs.SnapshotId, id.Name(), err)
+		}
+	}
+
+	return &awsOperation{}, nil
+}
+
+// AWSJanitorMetadata wraps the EC2 API and implements the JanitorMetadata
+// interface so AMIs (and their backing snapshots) can be deleted via the
+// deletion engine.
+type AWSJanitorMetadata struct {
+	Client            *ec2.Client
+	Items             []ec2types.Image
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+}
+
+// NewAWSJanitorMetadata creates a new instance of AWSJanitorMetadata.
+func NewAWSJanitorMetadata(c *ec2.Client, e time.Time, d bool, b []string, n string) *AWSJanitorMetadata {
+	return &AWSJanitorMetadata{
+		Client:            c,
+		ExpiredBefore:     e,
+		DeleteSingletons:  d,
+		BlacklistPatterns: b,
+		NameDelimiter:     n,
+	}
+}
+
+// Len returns the number of images still in the candidate list.
+func (i *AWSJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the EC2 API and populates i.Items with all AMIs owned by
+// the caller's account.
+func (i *AWSJanitorMetadata) Refresh() error {
+	out, err := i.Client.DescribeImages(context.Background(), &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+	})
+	if err != nil {
+		return fmt.Errorf("images: unable to list AMIs: %w", err)
+	}
+
+	for _, im := range out.Images {
+		metrics.ResourcesScanned.WithLabelValues("image", "", "").Inc()
+
+		log.WithFields(log.Fields{
+			"name":          imageName(im),
+			"creation_date": *im.CreationDate,
+			"image_id":      *im.ImageId,
+		}).Info("Found image")
+	}
+	i.Items = out.Images
+	return nil
+}
+
+// Blacklist removes blacklisted images from Images.Items.
+func (i *AWSJanitorMetadata) Blacklist() error {
+	var bi []ec2types.Image
+	if len(i.BlacklistPatterns) == 0 {
+		log.Debug("No blacklist patterns")
+		return nil
+	}
+	for _, im := range i.Items {
+		blacklisted := false
+		for _, p := range i.BlacklistPatterns {
+			r := regexp.MustCompile(p)
+			if r.MatchString(imageName(im)) {
+				blacklisted = true
+				log.WithFields(log.Fields{
+					"image":             imageName(im),
+					"blacklist_pattern": p,
+				}).Info("Image is blacklisted")
+				break
+			}
+		}
+		if !blacklisted {
+			bi = append(bi, im)
+		}
+	}
+	i.Items = bi
+	return nil
+}
+
+// Singletons removes images that by name are singleton. It does nothing when
+// DeleteSingletons is set.
+func (i *AWSJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []ec2types.Image{}
+	nsm := make(map[string]bool)
+	for _, im := range i.Items {
+		base := utils.GetResourceBasename(imageName(im), i.NameDelimiter)
+		if _, ok := nsm[base]; !ok {
+			nsm[base] = true
+			log.WithFields(log.Fields{
+				"name":   imageName(im),
+				"reason": "latest_version",
+				"delete": "ineligible",
+			}).Info("Image excluded from deletion")
+		} else {
+			il = append(il, im)
+			log.WithFields(log.Fields{
+				"name":   imageName(im),
+				"reason": "old_version",
+				"delete": "eligible",
+			}).Info("Image eligible for deletion")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes the images that are not past the ExpiredBefore time, or a
+// per-image override set via a "janitor-ttl" or "janitor-expires-at" tag.
+// An image whose creation date can't be parsed is dropped from the
+// candidate list and its error is recorded rather than aborting the whole
+// call.
+func (i *AWSJanitorMetadata) Expired() error {
+	iml := []ec2types.Image{}
+	var errs []string
+
+	for _, im := range i.Items {
+		if im.CreationDate == nil {
+			continue
+		}
+
+		stamp, err := time.Parse(time.RFC3339, *im.CreationDate)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":        err,
+				"creation_date": *im.CreationDate,
+				"image":         imageName(im),
+			}).Error("Failed to parse timestamp, skipping image")
+			errs = append(errs, fmt.Sprintf("%s: %s", imageName(im), err))
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(tagsToLabels(im.Tags), i.ExpiredBefore)
+		if stamp.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"image":         imageName(im),
+				"creation_date": *im.CreationDate,
+				"expired_by":    expiredBefore,
+			}).Info("Image is older than expired_by date")
+			iml = append(iml, im)
+		} else {
+			log.WithFields(log.Fields{
+				"image":         imageName(im),
+				"creation_date": *im.CreationDate,
+				"expired_by":    expiredBefore,
+			}).Info("Image is newer than expired_by date")
+		}
+	}
+	i.Items = iml
+	if len(errs) > 0 {
+		return fmt.Errorf("images: unable to parse creation date for %d image(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of AWSDeleteMetadata structs that can be used to
+// issue deletes for the list of images they wrap.
+func (i *AWSJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, im := range i.Items {
+		log.WithFields(log.Fields{
+			"name": imageName(im),
+		}).Debug("Generating deletion call for image")
+		d <- &AWSDeleteMetadata{
+			Client: i.Client,
+			Image:  im,
+		}
+	}
+	close(d)
+}
+
+// imageName returns the value of an AMI's Name field, falling back to its
+// image ID when unset.
+func imageName(im ec2types.Image) string {
+	if im.Name != nil {
+		return *im.Name
+	}
+	if im.ImageId != nil {
+		return *im.ImageId
+	}
+	return ""
+}
+
+// tagsToLabels converts an EC2 resource's tags into the map[string]string
+// form utils.EffectiveExpiredBefore expects.
+func tagsToLabels(tags []ec2types.Tag) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t.Key == nil || t.Value == nil {
+			continue
+		}
+		labels[*t.Key] = *t.Value
+	}
+	return labels
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+)
+
+// azureOperation wraps the long-running poller returned by the Azure SDK's
+// BeginDelete calls.
+type azureOperation struct {
+	Poller *runtime.Poller[armcompute.ImagesClientDeleteResponse]
+}
+
+// Wait blocks until the Azure delete operation completes or ctx is
+// cancelled.
+func (o *azureOperation) Wait(ctx context.Context) error {
+	_, err := o.Poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// AzureDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a Managed Image deletion call
+// and watch the resulting operation's status.
+type AzureDeleteMetadata struct {
+	Client        *armcompute.ImagesClient
+	ResourceGroup string
+	Image         *armcompute.Image
+}
+
+// Name returns the name of the managed image being deleted.
+func (id *AzureDeleteMetadata) Name() string {
+	return *id.Image.Name
+}
+
+// Project returns the resource group the managed image belongs to, Azure's
+// closest analogue to a project.
+func (id *AzureDeleteMetadata) Project() string {
+	return id.ResourceGroup
+}
+
+// Zone returns "" since this package doesn't track a region per managed
+// image.
+func (id *AzureDeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do issues the BeginDelete call for the given managed image.
+func (id *AzureDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"image": id.Name(),
+	}).Info("Issuing delete")
+
+	poller, err := id.Client.BeginDelete(context.Background(), id.ResourceGroup, *id.Image.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureOperation{Poller: poller}, nil
+}
+
+// AzureJanitorMetadata wraps the armcompute Images client and implements
+// the JanitorMetadata interface so Azure Managed Images can be deleted via
+// the deletion engine.
+type AzureJanitorMetadata struct {
+	Client            *armcompute.ImagesClient
+	ResourceGroup     string
+	Items             []*armcompute.Image
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+}
+
+// NewAzureJanitorMetadata creates a new instance of AzureJanitorMetadata.
+func NewAzureJanitorMetadata(c *armcompute.ImagesClient, rg string, e time.Time, d bool, b []string, n string) *AzureJanitorMetadata {
+	return &AzureJanitorMetadata{
+		Client:            c,
+		ResourceGroup:     rg,
+		ExpiredBefore:     e,
+		DeleteSingletons:  d,
+		BlacklistPatterns: b,
+		NameDelimiter:     n,
+	}
+}
+
+// Len returns the number of images still in the candidate list.
+func (i *AzureJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the Azure API and populates i.Items with all current
+// managed images in the resource group.
+func (i *AzureJanitorMetadata) Refresh() error {
+	var itl []*armcompute.Image
+	pager := i.Client.NewListByResourceGroupPager(i.ResourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("images: unable to list managed images: %w", err)
+		}
+
+		for _, im := range page.Value {
+			metrics.ResourcesScanned.WithLabelValues("image", i.ResourceGroup, "").Inc()
+
+			log.WithFields(log.Fields{
+				"name":           *im.Name,
+				"resource_group": i.ResourceGroup,
+			}).Info("Found image")
+			itl = append(itl, im)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// Blacklist removes blacklisted managed images.
+func (i *AzureJanitorMetadata) Blacklist() error {
+	var bi []*armcompute.Image
+	if len(i.BlacklistPatterns) == 0 {
+		log.Debug("No blacklist patterns")
+		return nil
+	}
+	for _, im := range i.Items {
+		blacklisted := false
+		for _, p := range i.BlacklistPatterns {
+			r := regexp.MustCompile(p)
+			if r.MatchString(*im.Name) {
+				blacklisted = true
+				log.WithFields(log.Fields{
+					"image":             *im.Name,
+					"blacklist_pattern": p,
+				}).Info("Image is blacklisted")
+				break
+			}
+		}
+		if !blacklisted {
+			bi = append(bi, im)
+		}
+	}
+	i.Items = bi
+	return nil
+}
+
+// Singletons removes managed images that by name are singleton. It does
+// nothing when DeleteSingletons is set.
+func (i *AzureJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*armcompute.Image{}
+	nsm := make(map[string]bool)
+	for _, im := range i.Items {
+		base := utils.GetResourceBasename(*im.Name, i.NameDelimiter)
+		if _, ok := nsm[base]; !ok {
+			nsm[base] = true
+			log.WithFields(log.Fields{
+				"name":   *im.Name,
+				"reason": "latest_version",
+				"delete": "ineligible",
+			}).Info("Image excluded from deletion")
+		} else {
+			il = append(il, im)
+			log.WithFields(log.Fields{
+				"name":   *im.Name,
+				"reason": "old_version",
+				"delete": "eligible",
+			}).Info("Image eligible for deletion")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes managed images that are not past the ExpiredBefore time,
+// or a per-image override set via a "janitor-ttl" or "janitor-expires-at"
+// tag. Unlike VMs, ARM doesn't expose a creation timestamp on the Image
+// resource, so this relies on a "creation-timestamp" tag (RFC3339) being
+// set when the image was created; untagged images are treated as fresh. An
+// image whose creation-timestamp tag can't be parsed is dropped from the
+// candidate list and its error is recorded rather than aborting the whole
+// call.
+func (i *AzureJanitorMetadata) Expired() error {
+	iml := []*armcompute.Image{}
+	var errs []string
+
+	for _, im := range i.Items {
+		ts, ok := im.Tags["creation-timestamp"]
+		if !ok || ts == nil {
+			continue
+		}
+
+		stamp, err := time.Parse(time.RFC3339, *ts)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": *ts,
+				"image":              *im.Name,
+			}).Error("Failed to parse timestamp, skipping image")
+			errs = append(errs, fmt.Sprintf("%s: %s", *im.Name, err))
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(tagsToLabels(im.Tags), i.ExpiredBefore)
+		if stamp.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"image":              *im.Name,
+				"creation_timestamp": *ts,
+				"expired_by":         expiredBefore,
+			}).Info("Image is older than expired_by date")
+			iml = append(iml, im)
+		} else {
+			log.WithFields(log.Fields{
+				"image":              *im.Name,
+				"creation_timestamp": *ts,
+				"expired_by":         expiredBefore,
+			}).Info("Image is newer than expired_by date")
+		}
+	}
+	i.Items = iml
+	if len(errs) > 0 {
+		return fmt.Errorf("images: unable to parse creation-timestamp tag for %d image(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of AzureDeleteMetadata structs that can be used to
+// issue deletes for the list of managed images they wrap.
+func (i *AzureJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, im := range i.Items {
+		log.WithFields(log.Fields{
+			"name":           *im.Name,
+			"resource_group": i.ResourceGroup,
+		}).Debug("Generating deletion call for image")
+		d <- &AzureDeleteMetadata{
+			Client:        i.Client,
+			ResourceGroup: i.ResourceGroup,
+			Image:         im,
+		}
+	}
+	close(d)
+}
+
+// tagsToLabels converts an ARM resource's tags into the map[string]string
+// form utils.EffectiveExpiredBefore expects.
+func tagsToLabels(tags map[string]*string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v == nil {
+			continue
+		}
+		labels[k] = *v
+	}
+	return labels
+}
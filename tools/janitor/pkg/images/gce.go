@@ -0,0 +1,297 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package images
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// GCEDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a GCE image deletion call and
+// watch the resulting delete operation's status.
+type GCEDeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Image     *compute.Image
+	Call      *compute.ImagesDeleteCall
+}
+
+// Name returns the name of the image being deleted.
+func (id *GCEDeleteMetadata) Name() string {
+	return id.Image.Name
+}
+
+// Project returns the project the image belongs to.
+func (id *GCEDeleteMetadata) Project() string {
+	return id.ProjectID
+}
+
+// Zone returns "" since images are a global resource.
+func (id *GCEDeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do executes the ImagesDeletionCall for the given image.
+func (id *GCEDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"image": id.Image.Name,
+	}).Info("Issuing delete")
+
+	op, err := id.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: id.Service, Project: id.ProjectID, Operation: op}, nil
+}
+
+// GCEJanitorMetadata wraps the Google Compute API image service and
+// implements the JanitorMetadata interface so GCE images can be deleted via
+// the deletion engine.
+type GCEJanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Image
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewGCEJanitorMetadata creates a new instance of GCEJanitorMetadata.
+func NewGCEJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *GCEJanitorMetadata {
+	return &GCEJanitorMetadata{
+		Project:          p,
+		NameDelimiter:    n,
+		DeleteSingletons: d,
+		Service:          s,
+		ExpiredBefore:    e,
+		Rules:            r,
+	}
+}
+
+// Len returns the number of images still in the candidate list.
+func (i *GCEJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with all current images.
+func (i *GCEJanitorMetadata) Refresh() error {
+	ilc := i.Service.Images.List(i.Project).OrderBy("creationTimestamp desc")
+	var itl []*compute.Image
+	for {
+		il, err := ilc.Do()
+		if err != nil {
+			return fmt.Errorf("images: unable to list images: %w", err)
+		}
+
+		for _, im := range il.Items {
+			metrics.ResourcesScanned.WithLabelValues("image", i.Project, "").Inc()
+
+			log.WithFields(log.Fields{
+				"name":               im.Name,
+				"creation_timestamp": im.CreationTimestamp,
+				"image_family":       im.Family,
+				"project":            i.Project,
+			}).Info("Found image")
+			itl = append(itl, im)
+		}
+
+		if il.NextPageToken == "" {
+			break
+		}
+		ilc = ilc.PageToken(il.NextPageToken)
+	}
+	i.Items = itl
+	return nil
+}
+
+// imageLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given image: its GCE resource labels plus a few
+// derived labels (family, age in days) that aren't already exposed as
+// labels by the API.
+func imageLabels(im *compute.Image, expiredBefore time.Time) map[string]string {
+	labels := make(map[string]string, len(im.Labels)+2)
+	for k, v := range im.Labels {
+		labels[k] = v
+	}
+	labels["family"] = im.Family
+
+	if stamp, err := utils.ParseCreationTimestamp(im.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every image's labels, dropping any image a
+// rule decides to drop and writing back whatever synthetic labels the rules
+// produced so that later stages (e.g. Expired) can key on them. An image
+// whose rules fail to evaluate is left out of the candidate list and its
+// error is recorded rather than aborting the whole call, so one bad image
+// doesn't block every other image's sweep.
+func (i *GCEJanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Image
+	var errs []string
+	for _, im := range i.Items {
+		labels, keep, err := relabel.Apply(imageLabels(im, i.ExpiredBefore), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"image": im.Name,
+				"error": err,
+			}).Error("Unable to evaluate relabel rules, skipping image")
+			errs = append(errs, fmt.Sprintf("%s: %s", im.Name, err))
+			continue
+		}
+
+		im.Labels = labels
+		if keep {
+			bi = append(bi, im)
+		} else {
+			log.WithFields(log.Fields{
+				"image":  im.Name,
+				"labels": labels,
+			}).Info("Image is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("images: unable to evaluate relabel rules for %d image(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes images that by name are singleton. It does nothing when
+// DeleteSingletons is set.
+func (i *GCEJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Image{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, im := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(im.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(im.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{im.Name, true}
+			log.WithFields(log.Fields{
+				"name":               im.Name,
+				"creation_timestamp": im.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Image excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(im.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{im.Name, false}
+			il = append(il, im)
+			log.WithFields(log.Fields{
+				"name":               im.Name,
+				"creation_timestamp": im.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Image eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Image is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes the images that are not past the ExpiredBefore time, or a
+// per-image override set via a "janitor-ttl" or "janitor-expires-at"
+// label. An image whose creation timestamp can't be parsed is dropped from
+// the candidate list and its error is recorded rather than aborting the
+// whole call.
+func (i *GCEJanitorMetadata) Expired() error {
+	iml := []*compute.Image{}
+	var errs []string
+
+	for _, in := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(in.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": in.CreationTimestamp,
+				"image":              in.Name,
+			}).Error("Failed to parse timestamp, skipping image")
+			errs = append(errs, fmt.Sprintf("%s: %s", in.Name, err))
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(in.Labels, i.ExpiredBefore)
+		if stamp.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"image":              in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"expired_by":         expiredBefore,
+			}).Info("Image is older than expired_by date")
+			iml = append(iml, in)
+		} else {
+			log.WithFields(log.Fields{
+				"image":              in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"expired_by":         expiredBefore,
+			}).Info("Image is newer than expired_by date")
+		}
+	}
+	i.Items = iml
+	if len(errs) > 0 {
+		return fmt.Errorf("images: unable to parse creation timestamp for %d image(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of GCEDeleteMetadata structs that can be used to
+// issue deletes for the list of images they wrap.
+func (i *GCEJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, im := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    im.Name,
+			"project": i.Project,
+		}).Debug("Generating deletion call for image")
+		d <- &GCEDeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Image:     im,
+			Call:      i.Service.Images.Delete(i.Project, im.Name),
+		}
+	}
+	close(d)
+}
@@ -0,0 +1,278 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+)
+
+// awsOperation waits on an EC2 instance reaching the terminated state.
+type awsOperation struct {
+	Client     *ec2.Client
+	InstanceID string
+}
+
+// Wait polls EC2 until the instance has finished terminating or ctx is
+// cancelled.
+func (o *awsOperation) Wait(ctx context.Context) error {
+	waiter := ec2.NewInstanceTerminatedWaiter(o.Client)
+	return waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{o.InstanceID},
+	}, 10*time.Minute)
+}
+
+// AWSDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue an EC2 instance termination call
+// and watch the resulting operation's status.
+type AWSDeleteMetadata struct {
+	Client   *ec2.Client
+	Instance ec2types.Instance
+}
+
+// Name returns the name of the instance being deleted, falling back to its
+// instance ID if it has no Name tag.
+func (id *AWSDeleteMetadata) Name() string {
+	return instanceName(id.Instance)
+}
+
+// Project returns "" since AWS instances aren't scoped to a project-like
+// identifier in this package.
+func (id *AWSDeleteMetadata) Project() string {
+	return ""
+}
+
+// Zone returns "" since AWS instances aren't scoped to a region here.
+func (id *AWSDeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do issues the TerminateInstances call for the given instance.
+func (id *AWSDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"instance": id.Name(),
+	}).Info("Issuing delete")
+
+	_, err := id.Client.TerminateInstances(context.Background(), &ec2.TerminateInstancesInput{
+		InstanceIds: []string{*id.Instance.InstanceId},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsOperation{Client: id.Client, InstanceID: *id.Instance.InstanceId}, nil
+}
+
+// AWSJanitorMetadata wraps the EC2 API and implements the JanitorMetadata
+// interface so EC2 instances can be deleted via the deletion engine.
+type AWSJanitorMetadata struct {
+	Client            *ec2.Client
+	Items             []ec2types.Instance
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+}
+
+// NewAWSJanitorMetadata creates a new instance of AWSJanitorMetadata.
+func NewAWSJanitorMetadata(c *ec2.Client, e time.Time, d bool, b []string, n string) *AWSJanitorMetadata {
+	return &AWSJanitorMetadata{
+		Client:            c,
+		ExpiredBefore:     e,
+		DeleteSingletons:  d,
+		BlacklistPatterns: b,
+		NameDelimiter:     n,
+	}
+}
+
+// Len returns the number of instances still in the candidate list.
+func (i *AWSJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the EC2 API and populates i.Items with all current, non-
+// terminated instances.
+func (i *AWSJanitorMetadata) Refresh() error {
+	var itl []ec2types.Instance
+	p := ec2.NewDescribeInstancesPaginator(i.Client, &ec2.DescribeInstancesInput{})
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("instances: unable to list EC2 instances: %w", err)
+		}
+
+		for _, r := range page.Reservations {
+			for _, in := range r.Instances {
+				metrics.ResourcesScanned.WithLabelValues("instance", "", "").Inc()
+
+				if in.State != nil && in.State.Name == ec2types.InstanceStateNameTerminated {
+					continue
+				}
+				log.WithFields(log.Fields{
+					"name":        instanceName(in),
+					"launch_time": in.LaunchTime,
+					"instance_id": *in.InstanceId,
+				}).Info("Found instance")
+				itl = append(itl, in)
+			}
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// Blacklist removes blacklisted instances.
+func (i *AWSJanitorMetadata) Blacklist() error {
+	var bi []ec2types.Instance
+	if len(i.BlacklistPatterns) == 0 {
+		log.Debug("No blacklist patterns")
+		return nil
+	}
+	for _, in := range i.Items {
+		blacklisted := false
+		for _, p := range i.BlacklistPatterns {
+			r := regexp.MustCompile(p)
+			if r.MatchString(instanceName(in)) {
+				blacklisted = true
+				log.WithFields(log.Fields{
+					"instance":          instanceName(in),
+					"blacklist_pattern": p,
+					"status":            "blacklisted",
+				}).Info("Instance is blacklisted")
+				break
+			}
+		}
+		if !blacklisted {
+			bi = append(bi, in)
+		}
+	}
+	i.Items = bi
+	return nil
+}
+
+// Singletons removes instances that are singleton by name. It does nothing
+// when DeleteSingletons is set.
+func (i *AWSJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []ec2types.Instance{}
+	nsm := make(map[string]bool)
+	for _, in := range i.Items {
+		base := utils.GetResourceBasename(instanceName(in), i.NameDelimiter)
+		if _, ok := nsm[base]; !ok {
+			nsm[base] = true
+			log.WithFields(log.Fields{
+				"name":   instanceName(in),
+				"status": "latest",
+				"delete": "ineligible",
+			}).Info("Instance excluded from deletion")
+		} else {
+			il = append(il, in)
+			log.WithFields(log.Fields{
+				"name":   instanceName(in),
+				"status": "superseded",
+				"delete": "eligible",
+			}).Info("Instance eligible for deletion")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes instances that are not past the ExpiredBefore time, or a
+// per-instance override set via a "janitor-ttl" or "janitor-expires-at"
+// tag.
+func (i *AWSJanitorMetadata) Expired() error {
+	inl := []ec2types.Instance{}
+
+	for _, in := range i.Items {
+		if in.LaunchTime == nil {
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(tagsToLabels(in.Tags), i.ExpiredBefore)
+		if in.LaunchTime.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"instance":    instanceName(in),
+				"launch_time": in.LaunchTime,
+				"expired_by":  expiredBefore,
+				"status":      "expired",
+			}).Info("Instance is older than expired_by date")
+			inl = append(inl, in)
+		} else {
+			log.WithFields(log.Fields{
+				"instance":    instanceName(in),
+				"launch_time": in.LaunchTime,
+				"expired_by":  expiredBefore,
+				"status":      "fresh",
+			}).Info("Instance is newer than expired_by date")
+		}
+	}
+	i.Items = inl
+	return nil
+}
+
+// Delete generates a list of AWSDeleteMetadata structs that can be used to
+// issue terminations for the list of instances they wrap.
+func (i *AWSJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, in := range i.Items {
+		log.WithFields(log.Fields{
+			"name": instanceName(in),
+		}).Debug("Generating deletion call for instance")
+		d <- &AWSDeleteMetadata{
+			Client:   i.Client,
+			Instance: in,
+		}
+	}
+	close(d)
+}
+
+// instanceName returns the value of an instance's Name tag, falling back to
+// its instance ID when no Name tag is present.
+func instanceName(in ec2types.Instance) string {
+	for _, t := range in.Tags {
+		if t.Key != nil && *t.Key == "Name" && t.Value != nil {
+			return *t.Value
+		}
+	}
+	if in.InstanceId != nil {
+		return *in.InstanceId
+	}
+	return ""
+}
+
+// tagsToLabels converts an EC2 resource's tags into the map[string]string
+// form utils.EffectiveExpiredBefore expects.
+func tagsToLabels(tags []ec2types.Tag) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t.Key == nil || t.Value == nil {
+			continue
+		}
+		labels[*t.Key] = *t.Value
+	}
+	return labels
+}
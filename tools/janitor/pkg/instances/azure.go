@@ -0,0 +1,260 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+)
+
+// azureOperation wraps the long-running poller returned by the Azure SDK's
+// BeginDelete calls.
+type azureOperation struct {
+	Poller *runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse]
+}
+
+// Wait blocks until the Azure delete operation completes or ctx is
+// cancelled.
+func (o *azureOperation) Wait(ctx context.Context) error {
+	_, err := o.Poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// AzureDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a Virtual Machine deletion call
+// and watch the resulting operation's status.
+type AzureDeleteMetadata struct {
+	Client        *armcompute.VirtualMachinesClient
+	ResourceGroup string
+	VM            *armcompute.VirtualMachine
+}
+
+// Name returns the name of the virtual machine being deleted.
+func (id *AzureDeleteMetadata) Name() string {
+	return *id.VM.Name
+}
+
+// Project returns the resource group the virtual machine belongs to,
+// Azure's closest analogue to a project.
+func (id *AzureDeleteMetadata) Project() string {
+	return id.ResourceGroup
+}
+
+// Zone returns "" since this package doesn't track a region per virtual
+// machine.
+func (id *AzureDeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do issues the BeginDelete call for the given virtual machine.
+func (id *AzureDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"instance": id.Name(),
+	}).Info("Issuing delete")
+
+	poller, err := id.Client.BeginDelete(context.Background(), id.ResourceGroup, *id.VM.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureOperation{Poller: poller}, nil
+}
+
+// AzureJanitorMetadata wraps the armcompute Virtual Machines client and
+// implements the JanitorMetadata interface so Azure VMs can be deleted via
+// the deletion engine.
+type AzureJanitorMetadata struct {
+	Client            *armcompute.VirtualMachinesClient
+	ResourceGroup     string
+	Items             []*armcompute.VirtualMachine
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+}
+
+// NewAzureJanitorMetadata creates a new instance of AzureJanitorMetadata.
+func NewAzureJanitorMetadata(c *armcompute.VirtualMachinesClient, rg string, e time.Time, d bool, b []string, n string) *AzureJanitorMetadata {
+	return &AzureJanitorMetadata{
+		Client:            c,
+		ResourceGroup:     rg,
+		ExpiredBefore:     e,
+		DeleteSingletons:  d,
+		BlacklistPatterns: b,
+		NameDelimiter:     n,
+	}
+}
+
+// Len returns the number of virtual machines still in the candidate list.
+func (i *AzureJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the Azure API and populates i.Items with all current
+// virtual machines in the resource group.
+func (i *AzureJanitorMetadata) Refresh() error {
+	var itl []*armcompute.VirtualMachine
+	pager := i.Client.NewListPager(i.ResourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return fmt.Errorf("instances: unable to list virtual machines: %w", err)
+		}
+
+		for _, vm := range page.Value {
+			metrics.ResourcesScanned.WithLabelValues("instance", i.ResourceGroup, "").Inc()
+
+			log.WithFields(log.Fields{
+				"name":           *vm.Name,
+				"resource_group": i.ResourceGroup,
+			}).Info("Found instance")
+			itl = append(itl, vm)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// Blacklist removes blacklisted virtual machines.
+func (i *AzureJanitorMetadata) Blacklist() error {
+	var bi []*armcompute.VirtualMachine
+	if len(i.BlacklistPatterns) == 0 {
+		log.Debug("No blacklist patterns")
+		return nil
+	}
+	for _, vm := range i.Items {
+		blacklisted := false
+		for _, p := range i.BlacklistPatterns {
+			r := regexp.MustCompile(p)
+			if r.MatchString(*vm.Name) {
+				blacklisted = true
+				log.WithFields(log.Fields{
+					"instance":          *vm.Name,
+					"blacklist_pattern": p,
+					"status":            "blacklisted",
+				}).Info("Instance is blacklisted")
+				break
+			}
+		}
+		if !blacklisted {
+			bi = append(bi, vm)
+		}
+	}
+	i.Items = bi
+	return nil
+}
+
+// Singletons removes virtual machines that are singleton by name. It does
+// nothing when DeleteSingletons is set.
+func (i *AzureJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*armcompute.VirtualMachine{}
+	nsm := make(map[string]bool)
+	for _, vm := range i.Items {
+		base := utils.GetResourceBasename(*vm.Name, i.NameDelimiter)
+		if _, ok := nsm[base]; !ok {
+			nsm[base] = true
+			log.WithFields(log.Fields{
+				"name":   *vm.Name,
+				"status": "latest",
+				"delete": "ineligible",
+			}).Info("Instance excluded from deletion")
+		} else {
+			il = append(il, vm)
+			log.WithFields(log.Fields{
+				"name":   *vm.Name,
+				"status": "superseded",
+				"delete": "eligible",
+			}).Info("Instance eligible for deletion")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes virtual machines that are not past the ExpiredBefore
+// time, keyed off each VM's TimeCreated property, or a per-VM override set via
+// a "janitor-ttl" or "janitor-expires-at" tag.
+func (i *AzureJanitorMetadata) Expired() error {
+	inl := []*armcompute.VirtualMachine{}
+
+	for _, vm := range i.Items {
+		if vm.Properties == nil || vm.Properties.TimeCreated == nil {
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(tagsToLabels(vm.Tags), i.ExpiredBefore)
+		if vm.Properties.TimeCreated.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"instance":     *vm.Name,
+				"time_created": vm.Properties.TimeCreated,
+				"expired_by":   expiredBefore,
+				"status":       "expired",
+			}).Info("Instance is older than expired_by date")
+			inl = append(inl, vm)
+		} else {
+			log.WithFields(log.Fields{
+				"instance":     *vm.Name,
+				"time_created": vm.Properties.TimeCreated,
+				"expired_by":   expiredBefore,
+				"status":       "fresh",
+			}).Info("Instance is newer than expired_by date")
+		}
+	}
+	i.Items = inl
+	return nil
+}
+
+// Delete generates a list of AzureDeleteMetadata structs that can be used to
+// issue deletes for the list of virtual machines they wrap.
+func (i *AzureJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, vm := range i.Items {
+		log.WithFields(log.Fields{
+			"name":           *vm.Name,
+			"resource_group": i.ResourceGroup,
+		}).Debug("Generating deletion call for instance")
+		d <- &AzureDeleteMetadata{
+			Client:        i.Client,
+			ResourceGroup: i.ResourceGroup,
+			VM:            vm,
+		}
+	}
+	close(d)
+}
+
+// tagsToLabels converts an ARM resource's tags into the map[string]string
+// form utils.EffectiveExpiredBefore expects.
+func tagsToLabels(tags map[string]*string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v == nil {
+			continue
+		}
+		labels[k] = *v
+	}
+	return labels
+}
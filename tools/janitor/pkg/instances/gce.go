@@ -0,0 +1,324 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// GCEDeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a GCE instance deletion call and
+// watch the resulting delete operation's status.
+type GCEDeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Instance  *compute.Instance
+	Call      *compute.InstancesDeleteCall
+}
+
+// Name returns the name of the instance being deleted.
+func (id *GCEDeleteMetadata) Name() string {
+	return id.Instance.Name
+}
+
+// Project returns the project the instance belongs to.
+func (id *GCEDeleteMetadata) Project() string {
+	return id.ProjectID
+}
+
+// Zone returns the zone the instance lives in.
+func (id *GCEDeleteMetadata) Zone() string {
+	return utils.GetResourceNameFromURL(id.Instance.Zone)
+}
+
+// Do executes the InstancesDeletionCall for the given instance.
+func (id *GCEDeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"instance": id.Instance.Name,
+	}).Info("Issuing delete")
+
+	op, err := id.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: id.Service, Project: id.ProjectID, Operation: op}, nil
+}
+
+// GCEJanitorMetadata wraps the Google Compute API Instance service and
+// implements the JanitorMetadata interface so GCE instances can be deleted
+// via the deletion engine.
+type GCEJanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Instance
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+	// Force, if set, includes instances with DeletionProtection enabled
+	// in the candidate list instead of excluding them.
+	Force bool
+}
+
+// NewGCEJanitorMetadata creates a new instance of GCEJanitorMetadata.
+func NewGCEJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string, force bool) *GCEJanitorMetadata {
+	return &GCEJanitorMetadata{
+		Project:          p,
+		NameDelimiter:    n,
+		DeleteSingletons: d,
+		Service:          s,
+		ExpiredBefore:    e,
+		Rules:            r,
+		Force:            force,
+	}
+}
+
+// Len returns the number of instances still in the candidate list.
+func (i *GCEJanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with all current
+// instances, excluding any with DeletionProtection enabled unless Force is
+// set.
+func (i *GCEJanitorMetadata) Refresh() error {
+	zones, err := i.Service.Zones.List(i.Project).Do()
+	if err != nil {
+		return fmt.Errorf("instances: unable to list zones: %w", err)
+	}
+	var itl []*compute.Instance
+	for _, zone := range zones.Items {
+		ilc := i.Service.Instances.List(i.Project, zone.Name).OrderBy("creationTimestamp desc")
+		for {
+			il, err := ilc.Do()
+			if err != nil {
+				return fmt.Errorf("instances: unable to list instances in zone %s: %w", zone.Name, err)
+			}
+
+			for _, in := range il.Items {
+				metrics.ResourcesScanned.WithLabelValues("instance", i.Project, zone.Name).Inc()
+
+				if in.DeletionProtection && !i.Force {
+					log.WithFields(log.Fields{
+						"name": in.Name,
+						"zone": zone.Name,
+					}).Debug("Instance has deletion protection enabled, skipping")
+					continue
+				}
+
+				log.WithFields(log.Fields{
+					"name":               in.Name,
+					"creation_timestamp": in.CreationTimestamp,
+					"project":            i.Project,
+					"zone":               zone.Name,
+				}).Info("Found instance")
+				itl = append(itl, in)
+			}
+
+			if il.NextPageToken == "" {
+				break
+			}
+			ilc = ilc.PageToken(il.NextPageToken)
+		}
+	}
+	i.Items = itl
+	return nil
+}
+
+// instanceLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given instance: its GCE resource labels plus a few
+// derived labels (network tags, zone, age in days) that aren't already
+// exposed as labels by the API.
+func instanceLabels(in *compute.Instance) map[string]string {
+	labels := make(map[string]string, len(in.Labels)+3)
+	for k, v := range in.Labels {
+		labels[k] = v
+	}
+	labels["zone"] = utils.GetResourceNameFromURL(in.Zone)
+	if in.Tags != nil {
+		labels["network_tags"] = strings.Join(in.Tags.Items, ",")
+	}
+
+	if stamp, err := utils.ParseCreationTimestamp(in.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every instance's labels, dropping any
+// instance a rule decides to drop and writing back whatever synthetic
+// labels the rules produced so that later stages (e.g. Expired) can key on
+// them. An instance whose rules fail to evaluate is left out of the
+// candidate list and its error is recorded rather than aborting the whole
+// call, so one bad instance doesn't block every other instance's sweep.
+func (i *GCEJanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Instance
+	var errs []string
+	for _, in := range i.Items {
+		labels, keep, err := relabel.Apply(instanceLabels(in), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"instance": in.Name,
+				"error":    err,
+			}).Error("Unable to evaluate relabel rules, skipping instance")
+			errs = append(errs, fmt.Sprintf("%s: %s", in.Name, err))
+			continue
+		}
+
+		in.Labels = labels
+		if keep {
+			bi = append(bi, in)
+		} else {
+			log.WithFields(log.Fields{
+				"instance": in.Name,
+				"labels":   labels,
+				"status":   "blacklisted",
+			}).Info("Instance is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("instances: unable to evaluate relabel rules for %d instance(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes instances that are singleton by name. It does nothing
+// when DeleteSingletons is set.
+func (i *GCEJanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Instance{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, in := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(in.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(in.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{in.Name, true}
+			log.WithFields(log.Fields{
+				"name":               in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"status":             "latest",
+				"delete":             "ineligible",
+			}).Info("Instance excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(in.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{in.Name, false}
+			il = append(il, in)
+			log.WithFields(log.Fields{
+				"name":               in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"status":             "superseded",
+				"delete":             "eligible",
+			}).Info("Instance eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Instance is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes the instances that are not past the ExpiredBefore time, or a
+// per-instance override set via a "janitor-ttl" or "janitor-expires-at"
+// label. An instance whose creation timestamp can't be parsed is dropped
+// from the candidate list and its error is recorded rather than aborting
+// the whole call.
+func (i *GCEJanitorMetadata) Expired() error {
+	inl := []*compute.Instance{}
+	var errs []string
+
+	for _, in := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(in.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": in.CreationTimestamp,
+				"image":              in.Name,
+			}).Error("Failed to parse timestamp, skipping instance")
+			errs = append(errs, fmt.Sprintf("%s: %s", in.Name, err))
+			continue
+		}
+
+		expiredBefore := utils.EffectiveExpiredBefore(in.Labels, i.ExpiredBefore)
+		if stamp.Before(expiredBefore) {
+			log.WithFields(log.Fields{
+				"image":              in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"expired_by":         expiredBefore,
+				"status":             "expired",
+			}).Info("Instance is older than expired_by date")
+			inl = append(inl, in)
+		} else {
+			log.WithFields(log.Fields{
+				"image":              in.Name,
+				"creation_timestamp": in.CreationTimestamp,
+				"expired_by":         expiredBefore,
+				"status":             "fresh",
+			}).Info("Instance is newer than expired_by date")
+		}
+	}
+	i.Items = inl
+	if len(errs) > 0 {
+		return fmt.Errorf("instances: unable to parse creation timestamp for %d instance(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of GCEDeleteMetadata structs that can be used to
+// issue deletes for the list of instances they wrap.
+func (i *GCEJanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, in := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    in.Name,
+			"project": i.Project,
+			"zone":    utils.GetResourceNameFromURL(in.Zone),
+		}).Debug("Generating deletion call for instance")
+		d <- &GCEDeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Instance:  in,
+			Call:      i.Service.Instances.Delete(i.Project, utils.GetResourceNameFromURL(in.Zone), in.Name),
+		}
+	}
+	close(d)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus metrics the janitor exposes on its
+// /metrics endpoint, covering every resource type and cloud provider the
+// janitor supports. They are labeled by resource_type, project, and zone.
+// zone holds a region value for regional resources and is empty for global
+// or provider-scoped resources that don't carry one (e.g. most AWS
+// resources); project is empty wherever the provider's ResourceDeleter
+// doesn't carry a project-like scope.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ResourcesScanned counts every resource a Refresh() call found,
+	// before any filtering is applied.
+	ResourcesScanned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "janitor_resources_scanned_total",
+		Help: "Total number of resources considered for deletion, before filtering.",
+	}, []string{"resource_type", "project", "zone"})
+
+	// ResourcesDeleted counts every resource successfully deleted.
+	ResourcesDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "janitor_resources_deleted_total",
+		Help: "Total number of resources successfully deleted.",
+	}, []string{"resource_type", "project", "zone"})
+
+	// DeleteErrors counts every delete call or operation-wait that
+	// failed.
+	DeleteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "janitor_delete_errors_total",
+		Help: "Total number of errors encountered while deleting resources.",
+	}, []string{"resource_type", "project", "zone"})
+
+	// OperationDuration tracks how long a delete operation took from
+	// being issued to reaching DONE.
+	OperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "janitor_operation_duration_seconds",
+		Help:    "Time spent waiting for a delete operation to complete.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"resource_type", "project", "zone"})
+)
@@ -0,0 +1,296 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networks implements the delete.JanitorMetadata interface for GCE
+// VPC networks. Networks are referenced by firewall rules and instances, so
+// this package must be run last, after pkg/firewalls and pkg/addresses have
+// released anything still pointing at the network.
+package networks
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a network deletion call and
+// watch the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Network   *compute.Network
+	Call      *compute.NetworksDeleteCall
+}
+
+// Name returns the name of the network being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Network.Name
+}
+
+// Project returns the project the network belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns "" since networks are a global resource.
+func (d *DeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do executes the NetworksDeleteCall for the given network.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"network": d.Network.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API network service and
+// implements the delete.JanitorMetadata interface so networks can be
+// deleted via the deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Network
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of networks still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with every network that
+// is not the default network. Whether a network still has subnetworks is not
+// checked.
+func (i *JanitorMetadata) Refresh() error {
+	nlc := i.Service.Networks.List(i.Project)
+	var itl []*compute.Network
+	for {
+		nl, err := nlc.Do()
+		if err != nil {
+			return fmt.Errorf("networks: unable to list networks: %w", err)
+		}
+
+		for _, n := range nl.Items {
+			metrics.ResourcesScanned.WithLabelValues("network", i.Project, "").Inc()
+
+			if n.Name == "default" {
+				log.WithField("name", n.Name).Debug("Skipping default network")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"name":               n.Name,
+				"creation_timestamp": n.CreationTimestamp,
+				"project":            i.Project,
+			}).Info("Found network")
+			itl = append(itl, n)
+		}
+
+		if nl.NextPageToken == "" {
+			break
+		}
+		nlc = nlc.PageToken(nl.NextPageToken)
+	}
+	i.Items = itl
+	return nil
+}
+
+// networkLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given network.
+func networkLabels(n *compute.Network) map[string]string {
+	labels := make(map[string]string, 1)
+
+	if stamp, err := utils.ParseCreationTimestamp(n.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every network's labels, dropping any
+// network a rule decides to drop. A network whose rules fail to evaluate
+// is left out of the candidate list and its error is recorded rather than
+// aborting the whole call, so one bad network doesn't block every other
+// network's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Network
+	var errs []string
+	for _, n := range i.Items {
+		labels, keep, err := relabel.Apply(networkLabels(n), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"network": n.Name,
+				"error":   err,
+			}).Error("Unable to evaluate relabel rules, skipping network")
+			errs = append(errs, fmt.Sprintf("%s: %s", n.Name, err))
+			continue
+		}
+
+		if keep {
+			bi = append(bi, n)
+		} else {
+			log.WithFields(log.Fields{
+				"network": n.Name,
+				"labels":  labels,
+			}).Info("Network is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("networks: unable to evaluate relabel rules for %d network(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes networks that by name are singleton. It does nothing
+// when DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Network{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, n := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(n.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(n.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{n.Name, true}
+			log.WithFields(log.Fields{
+				"name":               n.Name,
+				"creation_timestamp": n.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Network excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(n.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{n.Name, false}
+			il = append(il, n)
+			log.WithFields(log.Fields{
+				"name":               n.Name,
+				"creation_timestamp": n.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Network eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Network is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes networks that are not past the ExpiredBefore time. A network
+// whose creation timestamp can't be parsed is dropped from the candidate
+// list and its error is recorded rather than aborting the whole call.
+func (i *JanitorMetadata) Expired() error {
+	nl := []*compute.Network{}
+	var errs []string
+
+	for _, n := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(n.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": n.CreationTimestamp,
+				"network":            n.Name,
+			}).Error("Failed to parse timestamp, skipping network")
+			errs = append(errs, fmt.Sprintf("%s: %s", n.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"network":            n.Name,
+				"creation_timestamp": n.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Network is older than expired_by date")
+			nl = append(nl, n)
+		} else {
+			log.WithFields(log.Fields{
+				"network":            n.Name,
+				"creation_timestamp": n.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Network is newer than expired_by date")
+		}
+	}
+
+	i.Items = nl
+	if len(errs) > 0 {
+		return fmt.Errorf("networks: unable to parse creation timestamp for %d network(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of networks they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, n := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    n.Name,
+			"project": i.Project,
+		}).Debug("Generating deletion call for network")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Network:   n,
+			Call:      i.Service.Networks.Delete(i.Project, n.Name),
+		}
+	}
+	close(d)
+}
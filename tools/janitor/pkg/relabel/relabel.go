@@ -0,0 +1,162 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relabel implements a small Prometheus-style relabeling engine
+// (see Prometheus' pkg/relabel) that resource janitors can use to decide
+// whether a resource should be kept or dropped based on its labels, rather
+// than only matching on its name.
+package relabel
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Action is the relabeling action to take once a rule's regex has been
+// matched against its source labels.
+type Action string
+
+const (
+	// Keep drops the resource unless the regex matches.
+	Keep Action = "keep"
+	// Drop drops the resource if the regex matches.
+	Drop Action = "drop"
+	// HashMod sets TargetLabel to a hash of the concatenated source label
+	// values modulo Modulus. Typically paired with a further `keep` rule to
+	// only operate on a percentage of matching resources.
+	HashMod Action = "hashmod"
+	// LabelDrop removes every label whose name matches the regex.
+	LabelDrop Action = "labeldrop"
+)
+
+// DefaultSeparator is used to join SourceLabels values when no Separator is
+// configured, matching Prometheus' relabel config default.
+const DefaultSeparator = ";"
+
+// Config mirrors Prometheus' relabel.Config. It is declared in the
+// janitor's YAML config under a `rules` key and describes a single
+// relabeling step to run against a resource's labels.
+type Config struct {
+	// SourceLabels is the ordered list of label names whose values are
+	// concatenated (with Separator) before Regex is matched against them.
+	SourceLabels []string `yaml:"source_labels"`
+	// Separator joins SourceLabels values. Defaults to DefaultSeparator.
+	Separator string `yaml:"separator"`
+	// Regex is matched against the concatenated source label values for
+	// Keep/Drop, or against label names for LabelDrop.
+	Regex string `yaml:"regex"`
+	// TargetLabel names the label HashMod writes its result to.
+	TargetLabel string `yaml:"target_label"`
+	// Modulus is the divisor used by the HashMod action.
+	Modulus uint64 `yaml:"modulus"`
+	// Action is one of Keep, Drop, HashMod, or LabelDrop.
+	Action Action `yaml:"action"`
+}
+
+func (c *Config) separator() string {
+	if c.Separator != "" {
+		return c.Separator
+	}
+	return DefaultSeparator
+}
+
+// Apply runs every rule, in order, against labels and returns the
+// (possibly rewritten) label set along with whether the resource should be
+// kept. Apply never mutates the map passed in.
+func Apply(labels map[string]string, rules []*Config) (map[string]string, bool, error) {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+
+	for _, rule := range rules {
+		keep, err := apply(out, rule)
+		if err != nil {
+			return nil, false, err
+		}
+		if !keep {
+			return out, false, nil
+		}
+	}
+
+	return out, true, nil
+}
+
+func apply(labels map[string]string, rule *Config) (bool, error) {
+	switch rule.Action {
+	case LabelDrop:
+		re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			return false, fmt.Errorf("relabel: invalid regex %q: %w", rule.Regex, err)
+		}
+		for name := range labels {
+			if re.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+		return true, nil
+	case HashMod:
+		if rule.Modulus == 0 {
+			return false, fmt.Errorf("relabel: modulus must be non-zero for the hashmod action")
+		}
+		sum := fnv.New64a()
+		sum.Write([]byte(concat(labels, rule)))
+		labels[rule.TargetLabel] = fmt.Sprintf("%d", sum.Sum64()%rule.Modulus)
+		return true, nil
+	case Drop:
+		matched, err := matches(labels, rule)
+		if err != nil {
+			return false, err
+		}
+		return !matched, nil
+	case Keep, "":
+		matched, err := matches(labels, rule)
+		if err != nil {
+			return false, err
+		}
+		return matched, nil
+	default:
+		return false, fmt.Errorf("relabel: unknown action %q", rule.Action)
+	}
+}
+
+func matches(labels map[string]string, rule *Config) (bool, error) {
+	re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+	if err != nil {
+		return false, fmt.Errorf("relabel: invalid regex %q: %w", rule.Regex, err)
+	}
+	return re.MatchString(concat(labels, rule)), nil
+}
+
+func concat(labels map[string]string, rule *Config) string {
+	values := make([]string, 0, len(rule.SourceLabels))
+	for _, l := range rule.SourceLabels {
+		values = append(values, labels[l])
+	}
+	return strings.Join(values, rule.separator())
+}
+
+// SortedKeys returns labels' keys in sorted order, useful for deterministic
+// logging of a resource's synthetic label set.
+func SortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
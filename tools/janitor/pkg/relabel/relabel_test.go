@@ -0,0 +1,154 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relabel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyKeep(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		rules  []*Config
+		keep   bool
+	}{
+		{
+			name:   "matching regex keeps the resource",
+			labels: map[string]string{"env": "prod"},
+			rules:  []*Config{{SourceLabels: []string{"env"}, Regex: "prod", Action: Keep}},
+			keep:   true,
+		},
+		{
+			name:   "non-matching regex drops the resource",
+			labels: map[string]string{"env": "dev"},
+			rules:  []*Config{{SourceLabels: []string{"env"}, Regex: "prod", Action: Keep}},
+			keep:   false,
+		},
+		{
+			name:   "empty action defaults to keep semantics",
+			labels: map[string]string{"env": "dev"},
+			rules:  []*Config{{SourceLabels: []string{"env"}, Regex: "dev", Action: ""}},
+			keep:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, keep, err := Apply(c.labels, c.rules)
+			if err != nil {
+				t.Fatalf("Apply returned error: %s", err)
+			}
+			if keep != c.keep {
+				t.Errorf("Apply keep = %v, want %v", keep, c.keep)
+			}
+		})
+	}
+}
+
+func TestApplyDrop(t *testing.T) {
+	labels := map[string]string{"env": "dev"}
+	rules := []*Config{{SourceLabels: []string{"env"}, Regex: "dev", Action: Drop}}
+
+	_, keep, err := Apply(labels, rules)
+	if err != nil {
+		t.Fatalf("Apply returned error: %s", err)
+	}
+	if keep {
+		t.Errorf("Apply keep = true, want false for a matching drop rule")
+	}
+}
+
+func TestApplyLabelDrop(t *testing.T) {
+	labels := map[string]string{"env": "dev", "tmp-suffix": "abc", "keep-me": "yes"}
+	rules := []*Config{{Regex: "tmp-.*", Action: LabelDrop}}
+
+	out, keep, err := Apply(labels, rules)
+	if err != nil {
+		t.Fatalf("Apply returned error: %s", err)
+	}
+	if !keep {
+		t.Fatalf("Apply keep = false, want true for labeldrop")
+	}
+	want := map[string]string{"env": "dev", "keep-me": "yes"}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Apply labels = %v, want %v", out, want)
+	}
+}
+
+func TestApplyHashMod(t *testing.T) {
+	labels := map[string]string{"name": "instance-1"}
+	rules := []*Config{{SourceLabels: []string{"name"}, TargetLabel: "shard", Modulus: 10, Action: HashMod}}
+
+	out, keep, err := Apply(labels, rules)
+	if err != nil {
+		t.Fatalf("Apply returned error: %s", err)
+	}
+	if !keep {
+		t.Fatalf("Apply keep = false, want true for hashmod")
+	}
+	if _, ok := out["shard"]; !ok {
+		t.Errorf("Apply did not set target label %q", "shard")
+	}
+}
+
+func TestApplyHashModZeroModulus(t *testing.T) {
+	labels := map[string]string{"name": "instance-1"}
+	rules := []*Config{{SourceLabels: []string{"name"}, TargetLabel: "shard", Modulus: 0, Action: HashMod}}
+
+	if _, _, err := Apply(labels, rules); err == nil {
+		t.Errorf("Apply with zero modulus returned nil error, want non-nil")
+	}
+}
+
+func TestApplyInvalidRegex(t *testing.T) {
+	labels := map[string]string{"env": "dev"}
+	rules := []*Config{{SourceLabels: []string{"env"}, Regex: "(", Action: Keep}}
+
+	if _, _, err := Apply(labels, rules); err == nil {
+		t.Errorf("Apply with invalid regex returned nil error, want non-nil")
+	}
+}
+
+func TestApplyUnknownAction(t *testing.T) {
+	labels := map[string]string{"env": "dev"}
+	rules := []*Config{{Action: "bogus"}}
+
+	if _, _, err := Apply(labels, rules); err == nil {
+		t.Errorf("Apply with unknown action returned nil error, want non-nil")
+	}
+}
+
+func TestApplyDoesNotMutateInput(t *testing.T) {
+	labels := map[string]string{"tmp-suffix": "abc"}
+	rules := []*Config{{Regex: "tmp-.*", Action: LabelDrop}}
+
+	if _, _, err := Apply(labels, rules); err != nil {
+		t.Fatalf("Apply returned error: %s", err)
+	}
+	if _, ok := labels["tmp-suffix"]; !ok {
+		t.Errorf("Apply mutated the input map; want the original map untouched")
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	labels := map[string]string{"b": "2", "a": "1", "c": "3"}
+	got := SortedKeys(labels)
+	want := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortedKeys = %v, want %v", got, want)
+	}
+}
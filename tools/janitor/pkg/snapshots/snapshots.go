@@ -0,0 +1,305 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snapshots implements the delete.JanitorMetadata interface for GCE
+// persistent disk snapshots. It should be run after pkg/disks so that
+// snapshots whose source disk was just deleted are already candidates.
+package snapshots
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/gce"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/metrics"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/relabel"
+	"github.com/GoogleCloudPlatform/professional-services/tools/janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DeleteMetadata implements the delete.ResourceDeleter interface and
+// provides the functions necessary to issue a snapshot deletion call and
+// watch the resulting delete operation's status.
+type DeleteMetadata struct {
+	Service   *compute.Service
+	ProjectID string
+	Snapshot  *compute.Snapshot
+	Call      *compute.SnapshotsDeleteCall
+}
+
+// Name returns the name of the snapshot being deleted.
+func (d *DeleteMetadata) Name() string {
+	return d.Snapshot.Name
+}
+
+// Project returns the project the snapshot belongs to.
+func (d *DeleteMetadata) Project() string {
+	return d.ProjectID
+}
+
+// Zone returns "" since snapshots are a global resource.
+func (d *DeleteMetadata) Zone() string {
+	return ""
+}
+
+// Do executes the SnapshotsDeleteCall for the given snapshot.
+func (d *DeleteMetadata) Do() (delete.Operation, error) {
+	log.WithFields(log.Fields{
+		"snapshot": d.Snapshot.Name,
+	}).Info("Issuing delete")
+
+	op, err := d.Call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gce.Operation{Service: d.Service, Project: d.ProjectID, Operation: op}, nil
+}
+
+// JanitorMetadata wraps the Google Compute API snapshot service and
+// implements the delete.JanitorMetadata interface so snapshots can be
+// deleted via the deletion engine.
+type JanitorMetadata struct {
+	Service          *compute.Service
+	Project          string
+	Items            []*compute.Snapshot
+	ExpiredBefore    time.Time
+	DeleteSingletons bool
+	Rules            []*relabel.Config
+	NameDelimiter    string
+}
+
+// NewJanitorMetadata creates a new instance of JanitorMetadata.
+func NewJanitorMetadata(s *compute.Service, p string, e time.Time, d bool, r []*relabel.Config, n string) *JanitorMetadata {
+	return &JanitorMetadata{
+		Service:          s,
+		Project:          p,
+		ExpiredBefore:    e,
+		DeleteSingletons: d,
+		Rules:            r,
+		NameDelimiter:    n,
+	}
+}
+
+// Len returns the number of snapshots still in the candidate list.
+func (i *JanitorMetadata) Len() int {
+	return len(i.Items)
+}
+
+// Refresh queries the GCE API and populates i.Items with every snapshot
+// whose source disk no longer exists.
+func (i *JanitorMetadata) Refresh() error {
+	slc := i.Service.Snapshots.List(i.Project).OrderBy("creationTimestamp desc")
+	var itl []*compute.Snapshot
+	for {
+		sl, err := slc.Do()
+		if err != nil {
+			return fmt.Errorf("snapshots: unable to list snapshots: %w", err)
+		}
+
+		for _, s := range sl.Items {
+			metrics.ResourcesScanned.WithLabelValues("snapshot", i.Project, "").Inc()
+
+			if s.SourceDisk != "" {
+				// SourceDisk is a URL ending in .../zones/<zone>/disks/<name>.
+				parts := strings.Split(s.SourceDisk, "/")
+				if len(parts) >= 3 {
+					if _, err := i.Service.Disks.Get(i.Project, parts[len(parts)-3], parts[len(parts)-1]).Do(); err == nil {
+						log.WithField("name", s.Name).Debug("Snapshot's source disk still exists, skipping")
+						continue
+					}
+				}
+			}
+			log.WithFields(log.Fields{
+				"name":               s.Name,
+				"creation_timestamp": s.CreationTimestamp,
+				"source_disk":        s.SourceDisk,
+				"project":            i.Project,
+			}).Info("Found snapshot")
+			itl = append(itl, s)
+		}
+
+		if sl.NextPageToken == "" {
+			break
+		}
+		slc = slc.PageToken(sl.NextPageToken)
+	}
+	i.Items = itl
+	return nil
+}
+
+// snapshotLabels builds the synthetic label set a relabel.Config rule can
+// select on for a given snapshot.
+func snapshotLabels(s *compute.Snapshot) map[string]string {
+	labels := make(map[string]string, len(s.Labels)+1)
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+
+	if stamp, err := utils.ParseCreationTimestamp(s.CreationTimestamp); err == nil {
+		labels["age_days"] = fmt.Sprintf("%d", int(time.Since(stamp).Hours()/24))
+	}
+
+	return labels
+}
+
+// Blacklist runs i.Rules against every snapshot's labels, dropping any
+// snapshot a rule decides to drop. A snapshot whose rules fail to evaluate
+// is left out of the candidate list and its error is recorded rather than
+// aborting the whole call, so one bad snapshot doesn't block every other
+// snapshot's sweep.
+func (i *JanitorMetadata) Blacklist() error {
+	if len(i.Rules) == 0 {
+		log.Debug("No relabel rules configured")
+		return nil
+	}
+
+	var bi []*compute.Snapshot
+	var errs []string
+	for _, s := range i.Items {
+		labels, keep, err := relabel.Apply(snapshotLabels(s), i.Rules)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"snapshot": s.Name,
+				"error":    err,
+			}).Error("Unable to evaluate relabel rules, skipping snapshot")
+			errs = append(errs, fmt.Sprintf("%s: %s", s.Name, err))
+			continue
+		}
+
+		s.Labels = labels
+		if keep {
+			bi = append(bi, s)
+		} else {
+			log.WithFields(log.Fields{
+				"snapshot": s.Name,
+				"labels":   labels,
+			}).Info("Snapshot is blacklisted")
+		}
+	}
+	i.Items = bi
+	if len(errs) > 0 {
+		return fmt.Errorf("snapshots: unable to evaluate relabel rules for %d snapshot(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Singletons removes snapshots that by name are singleton. It does nothing
+// when DeleteSingletons is set.
+func (i *JanitorMetadata) Singletons() {
+	if i.DeleteSingletons {
+		return
+	}
+	il := []*compute.Snapshot{}
+	nsm := make(map[string]struct {
+		Name      string
+		Singleton bool
+	})
+	for _, s := range i.Items {
+		if _, ok := nsm[utils.GetResourceBasename(s.Name, i.NameDelimiter)]; !ok {
+			nsm[utils.GetResourceBasename(s.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{s.Name, true}
+			log.WithFields(log.Fields{
+				"name":               s.Name,
+				"creation_timestamp": s.CreationTimestamp,
+				"reason":             "latest_version",
+				"delete":             "ineligible",
+			}).Info("Snapshot excluded from deletion")
+		} else {
+			nsm[utils.GetResourceBasename(s.Name, i.NameDelimiter)] = struct {
+				Name      string
+				Singleton bool
+			}{s.Name, false}
+			il = append(il, s)
+			log.WithFields(log.Fields{
+				"name":               s.Name,
+				"creation_timestamp": s.CreationTimestamp,
+				"reason":             "old_version",
+				"delete":             "eligible",
+			}).Info("Snapshot eligible for deletion")
+		}
+	}
+	for _, v := range nsm {
+		if v.Singleton == true {
+			log.WithField("name", v.Name).Info("Snapshot is a singleton")
+		}
+	}
+	i.Items = il
+}
+
+// Expired removes snapshots that are not past the ExpiredBefore time. A snapshot
+// whose creation timestamp can't be parsed is dropped from the candidate
+// list and its error is recorded rather than aborting the whole call.
+func (i *JanitorMetadata) Expired() error {
+	sl := []*compute.Snapshot{}
+	var errs []string
+
+	for _, s := range i.Items {
+		stamp, err := utils.ParseCreationTimestamp(s.CreationTimestamp)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"reason":             err,
+				"creation_timestamp": s.CreationTimestamp,
+				"snapshot":           s.Name,
+			}).Error("Failed to parse timestamp, skipping snapshot")
+			errs = append(errs, fmt.Sprintf("%s: %s", s.Name, err))
+			continue
+		}
+
+		if stamp.Before(i.ExpiredBefore) {
+			log.WithFields(log.Fields{
+				"snapshot":           s.Name,
+				"creation_timestamp": s.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Snapshot is older than expired_by date")
+			sl = append(sl, s)
+		} else {
+			log.WithFields(log.Fields{
+				"snapshot":           s.Name,
+				"creation_timestamp": s.CreationTimestamp,
+				"expired_by":         i.ExpiredBefore,
+			}).Info("Snapshot is newer than expired_by date")
+		}
+	}
+
+	i.Items = sl
+	if len(errs) > 0 {
+		return fmt.Errorf("snapshots: unable to parse creation timestamp for %d snapshot(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Delete generates a list of DeleteMetadata structs that can be used to
+// issue deletes for the list of snapshots they wrap.
+func (i *JanitorMetadata) Delete(d chan<- delete.ResourceDeleter) {
+	for _, s := range i.Items {
+		log.WithFields(log.Fields{
+			"name":    s.Name,
+			"project": i.Project,
+		}).Debug("Generating deletion call for snapshot")
+		d <- &DeleteMetadata{
+			Service:   i.Service,
+			ProjectID: i.Project,
+			Snapshot:  s,
+			Call:      i.Service.Snapshots.Delete(i.Project, s.Name),
+		}
+	}
+	close(d)
+}
@@ -45,3 +45,30 @@ func GetResourceNameFromURL(z string) string {
 	splitResource := strings.Split(z, "/")
 	return splitResource[len(splitResource)-1]
 }
+
+// EffectiveExpiredBefore returns the cutoff a resource's creation timestamp
+// should be compared against to decide whether it has expired. By default
+// that's fallback (the janitor's global --older-than cutoff), but a
+// resource can opt out of it with a "janitor-ttl" label (a
+// time.ParseDuration string, relative to now) or a "janitor-expires-at"
+// label (an RFC3339 timestamp, compared against now directly instead of
+// the resource's creation time). A malformed or absent override falls back
+// to fallback.
+func EffectiveExpiredBefore(labels map[string]string, fallback time.Time) time.Time {
+	if v, ok := labels["janitor-ttl"]; ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return time.Now().Add(-d)
+		}
+	}
+
+	if v, ok := labels["janitor-expires-at"]; ok {
+		if expiresAt, err := time.Parse(time.RFC3339, v); err == nil {
+			if time.Now().After(expiresAt) {
+				return time.Now()
+			}
+			return time.Time{}
+		}
+	}
+
+	return fallback
+}
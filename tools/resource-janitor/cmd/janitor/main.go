@@ -15,36 +15,91 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
 	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/janitor"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/projects"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/resources"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
 	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// defaultBlacklistKey is the BlacklistFileConfig key used for projects that
+// don't have their own entry.
+const defaultBlacklistKey = "default"
+
+// BlacklistConfig stores, per resource type, a list of name patterns that
+// should never be deleted.
 type BlacklistConfig struct {
-	Instances []string `yaml:"instances"`
-	Images    []string `yaml:"images"`
+	Instances       []string `yaml:"instances"`
+	Images          []string `yaml:"images"`
+	Disks           []string `yaml:"disks"`
+	Snapshots       []string `yaml:"snapshots"`
+	Addresses       []string `yaml:"addresses"`
+	ForwardingRules []string `yaml:"forwarding_rules"`
+	TargetPools     []string `yaml:"target_pools"`
+}
+
+// BlacklistFileConfig is the shape of --blacklist-file: a BlacklistConfig
+// per project ID, plus an optional "default" entry used for any project
+// without its own. A flat (pre-multi-project) blacklist file that sets
+// the resource-type keys directly at the top level is also accepted and
+// treated as the default entry.
+type BlacklistFileConfig struct {
+	BlacklistConfig `yaml:",inline"`
+	Projects        map[string]BlacklistConfig `yaml:"projects"`
+}
+
+// forProject returns the BlacklistConfig for project, falling back to the
+// "default" projects entry, and finally to any top-level resource-type
+// keys set outside of "projects" (the single-project file format).
+func (c BlacklistFileConfig) forProject(project string) BlacklistConfig {
+	if cfg, ok := c.Projects[project]; ok {
+		return cfg
+	}
+	if cfg, ok := c.Projects[defaultBlacklistKey]; ok {
+		return cfg
+	}
+	return c.BlacklistConfig
 }
 
 func main() {
-	project := flag.String("project", "foo", "ID of the project to clean up")
+	project := flag.String("project", "foo", "ID of the project to clean up, or a comma-separated list of IDs. Ignored if --folder or --organization is set.")
+	folder := flag.String("folder", "", "Resource Manager folder (e.g. \"folders/123\") to sweep every active project under, instead of a fixed --project list. Only projects directly parented by this folder are included; projects in its sub-folders are not swept unless this tool is invoked again with --folder set to each sub-folder.")
+	organization := flag.String("organization", "", "Resource Manager organization (e.g. \"organizations/123\") to sweep every active project under, instead of a fixed --project list. Only projects directly parented by the organization are included; projects parented by a folder anywhere under it are not swept.")
+	projectConcurrency := flag.Int("project-concurrency", 5, "Maximum number of projects swept at the same time.")
 	nameDelimiter := flag.String("image-delimiter", "-", "Delimiter used to separate parts of resource name")
 	workers := flag.Int("workers", 10, "Delimiter used to separate parts of the resource name")
 	olderThan := flag.Int64("older-than", 2592000, "Time in seconds that resources should not be older than")
 	logFile := flag.String("log-file", "", "File to which output is sent. Default is STDOUT.")
-	blacklistFile := flag.String("blacklist-file", "", "YAML config file with a list of naming schemes to ignore")
+	blacklistFile := flag.String("blacklist-file", "", "YAML config file with a list of naming schemes to ignore, optionally keyed by project ID under a top-level \"projects\" map (with a \"default\" entry as fallback).")
 	deleteSingletons := flag.Bool("delete-singletons", false, "If set, all resources that are older than the time specified will be deleted regardless of whether they are the only resource of a certain name.")
 	notDryRun := flag.Bool("not-dry-run", false, "Logs the changes that will be made without taking any actions.")
+	selectorExpr := flag.String("selector", "", "Kubernetes-style label selector (e.g. \"env=dev,tier!=prod,owner in (alice,bob)\"). Only resources whose labels satisfy the selector are considered for deletion.")
+	groupByLabel := flag.String("group-by-label", "", "If set, group resources for the singleton check by the value of this label instead of by name-delimited prefix. Resources without the label fall back to the name-delimited prefix.")
+	qps := flag.Float64("qps", 10, "Maximum Compute API delete and operation-poll requests per second, shared by the delete workers of one resource type in one project.")
+	burst := flag.Int("burst", 10, "Maximum burst size allowed above --qps.")
+	reportPath := flag.String("report", "", "If set, write a machine-readable JSON report of every candidate, deletion, and error to this path. With more than one project swept, the project ID is appended to the filename.")
+	auditSinkExpr := flag.String("audit-sink", "", "If set, publish the same structured events as --report to an audit sink: \"cloudlogging://<log-name>\" or \"pubsub://<topic-id>\".")
+	auditProject := flag.String("audit-sink-project", "", "Project the --audit-sink's Cloud Logging log or Pub/Sub topic lives in. Defaults to the first project swept.")
 
 	flag.Parse()
 
@@ -57,36 +112,107 @@ func main() {
 		log.SetOutput(file)
 	}
 
-	blacklistConfig := BlacklistConfig{
-		Instances: []string{},
-		Images:    []string{},
-	}
+	blacklistFileConfig := BlacklistFileConfig{}
 	if *blacklistFile != "" {
 		blacklist, err := ioutil.ReadFile(*blacklistFile)
 		if err != nil {
 			fmt.Printf("main.go: unable to open blacklist file: %s", err)
 		}
 
-		err = yaml.Unmarshal(blacklist, &blacklistConfig)
+		err = yaml.Unmarshal(blacklist, &blacklistFileConfig)
 		if err != nil {
 			fmt.Printf("main.go: unable to parse blacklist file: %s", err)
 		}
 	}
 
-	compute, err := initClient()
+	computeSvc, err := initClient()
 	if err != nil {
 		log.Fatalf("main.go: unable to initialize Compute Engine client: %s", err)
 	}
 
+	var crmSvc *cloudresourcemanager.Service
+	if *folder != "" || *organization != "" {
+		crmSvc, err = initResourceManagerClient()
+		if err != nil {
+			log.Fatalf("main.go: unable to initialize Resource Manager client: %s", err)
+		}
+	}
+
+	sel, err := selector.Parse(*selectorExpr)
+	if err != nil {
+		log.Fatalf("main.go: unable to parse --selector: %s", err)
+	}
+
+	projectIDs, err := projects.Resolve(crmSvc, *project, *folder, *organization)
+	if err != nil {
+		log.Fatalf("main.go: unable to resolve projects: %s", err)
+	}
+	if len(projectIDs) == 0 {
+		log.Fatalf("main.go: no projects to sweep")
+	}
+
+	ctx := context.Background()
+	sinkProject := *auditProject
+	if sinkProject == "" {
+		sinkProject = projectIDs[0]
+	}
+	sink, err := audit.ParseSink(ctx, sinkProject, *auditSinkExpr)
+	if err != nil {
+		log.Fatalf("main.go: unable to configure --audit-sink: %s", err)
+	}
+
+	runID := newRunID()
 	tooOld := utils.GetTooOldTime(*olderThan)
 
+	sem := make(chan struct{}, *projectConcurrency)
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go deleteImages(compute, *project, tooOld, *nameDelimiter, *workers, *notDryRun, *deleteSingletons, blacklistConfig.Images, &wg)
-	go deleteInstances(compute, *project, tooOld, *nameDelimiter, *workers, *notDryRun, *deleteSingletons, blacklistConfig.Instances, &wg)
+	wg.Add(len(projectIDs))
+	for _, projectID := range projectIDs {
+		go func(projectID string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			report := sweepProject(computeSvc, projectID, blacklistFileConfig.forProject(projectID), tooOld, *deleteSingletons, *nameDelimiter, sel, *groupByLabel, *workers, *qps, *burst, *notDryRun)
+			report.RunID = runID
+
+			if *reportPath != "" {
+				path := reportFilePath(*reportPath, projectID, len(projectIDs))
+				if err := report.WriteFile(path); err != nil {
+					log.Printf("main.go: project=%s: unable to write report: %s", projectID, err)
+				}
+			}
+			if sink != nil {
+				if err := sink.Publish(ctx, report); err != nil {
+					log.Printf("main.go: project=%s: unable to publish to audit sink: %s", projectID, err)
+				}
+			}
+		}(projectID)
+	}
 	wg.Wait()
 }
 
+// newRunID generates a short identifier shared by every project's report
+// and audit-sink events from a single invocation of the janitor.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("run-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// reportFilePath returns the path --report should be written to for one
+// project. When only one project is being swept, path is used as-is;
+// otherwise the project ID is inserted before the file extension so
+// concurrent project sweeps don't clobber each other's report.
+func reportFilePath(path, project string, numProjects int) string {
+	if numProjects <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + project + ext
+}
+
 func initClient() (*compute.Service, error) {
 	client, err := google.DefaultClient(oauth2.NoContext,
 		"https://www.googleapis.com/auth/compute")
@@ -102,49 +228,103 @@ func initClient() (*compute.Service, error) {
 	return computeService, nil
 }
 
-func deleteImages(computeSvc *compute.Service, project string, tooOld time.Time, nameDelimiter string, workers int, notDryRun bool, deleteSingletons bool, blacklist []string, wg *sync.WaitGroup) {
-	images, err := utils.GetOldAndNonSingletonImages(computeSvc, project, tooOld, deleteSingletons, blacklist, nameDelimiter)
+func initResourceManagerClient() (*cloudresourcemanager.Service, error) {
+	client, err := google.DefaultClient(oauth2.NoContext,
+		"https://www.googleapis.com/auth/cloud-platform.read-only")
 	if err != nil {
-		log.Fatalf("main.go: unable to get list of images older than %s: %s", tooOld, err)
+		return nil, err
 	}
 
-	if len(images) == 0 {
-		log.Printf("main.go: no images to delete")
-	}
+	return cloudresourcemanager.New(client)
+}
 
-	if notDryRun {
-		log.Printf("main.go: issuing parallel image delete.")
+// sweepProject registers every resource type for one project and sweeps
+// them concurrently, returning an audit.Report of every candidate,
+// deletion, and error seen. A resource type failing to refresh is logged
+// and skipped rather than aborting the project, so one bad resource type
+// or project can't take down the rest of an org-wide sweep.
+func sweepProject(computeSvc *compute.Service, project string, blacklist BlacklistConfig, tooOld time.Time, deleteSingletons bool, nameDelimiter string, sel selector.Selector, groupByLabel string, workers int, qps float64, burst int, notDryRun bool) *audit.Report {
+	log.Printf("main.go: project=%s: starting sweep", project)
 
-		err = delete.ParallelImages(computeSvc, project, workers, images)
-		if err != nil {
-			log.Fatalf("main.go: deletion exited with an error: %s", err)
-		}
+	report := &audit.Report{Project: project, StartedAt: time.Now()}
+	var reportMu sync.Mutex
+
+	// resourceTypes is the registered set of janitor.Resource
+	// implementations this run sweeps. Adding a new Compute Engine
+	// resource type only requires an implementation in pkg/resources and
+	// an entry here; main.go's sweep loop doesn't change.
+	resourceTypes := []janitor.Resource{
+		resources.NewImages(computeSvc, project, tooOld, deleteSingletons, blacklist.Images, nameDelimiter, sel, groupByLabel),
+		resources.NewInstances(computeSvc, project, tooOld, deleteSingletons, blacklist.Instances, nameDelimiter, sel, groupByLabel),
+		resources.NewDisks(computeSvc, project, tooOld, deleteSingletons, blacklist.Disks, nameDelimiter, sel, groupByLabel),
+		resources.NewSnapshots(computeSvc, project, tooOld, deleteSingletons, blacklist.Snapshots, nameDelimiter, sel, groupByLabel),
+		resources.NewAddresses(computeSvc, project, tooOld, deleteSingletons, blacklist.Addresses, nameDelimiter, sel, groupByLabel),
+		resources.NewForwardingRules(computeSvc, project, tooOld, deleteSingletons, blacklist.ForwardingRules, nameDelimiter, sel, groupByLabel),
+		resources.NewTargetPools(computeSvc, project, tooOld, deleteSingletons, blacklist.TargetPools, nameDelimiter, sel, groupByLabel),
+	}
 
-		log.Printf("main.go: successfully deleted old images")
+	var wg sync.WaitGroup
+	wg.Add(len(resourceTypes))
+	for _, r := range resourceTypes {
+		go sweep(computeSvc, project, r, workers, qps, burst, notDryRun, report, &reportMu, &wg)
 	}
+	wg.Wait()
 
-	wg.Done()
+	report.FinishedAt = time.Now()
+	return report
 }
 
-func deleteInstances(computeSvc *compute.Service, project string, tooOld time.Time, nameDelimiter string, workers int, notDryRun bool, deleteSingletons bool, blacklist []string, wg *sync.WaitGroup) {
-	instances, err := utils.GetOldAndNonSingletonInstances(computeSvc, project, tooOld, deleteSingletons, blacklist, nameDelimiter)
-	if err != nil {
-		log.Fatalf("main.go: unable to get list of instances older than %s: %s", tooOld, err)
+// sweep runs one janitor.Resource implementation through Refresh,
+// Blacklist, Select, Singletons, and Expired, then issues deletes for
+// whatever remains unless notDryRun is false. A refresh failure for one
+// resource type is logged and skipped rather than fatal, so it doesn't
+// take down the other resource types or projects in the sweep; a delete
+// failure for one resource type is likewise logged but doesn't stop the
+// rest. Every candidate's decision, every successful deletion, and every
+// failure is recorded onto report (guarded by reportMu, since sibling
+// resource types record onto the same report concurrently).
+func sweep(computeSvc *compute.Service, project string, r janitor.Resource, workers int, qps float64, burst int, notDryRun bool, report *audit.Report, reportMu *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if err := r.Refresh(); err != nil {
+		msg := fmt.Sprintf("project=%s: unable to refresh %ss: %s", project, r.Kind(), err)
+		log.Printf("main.go: %s", msg)
+		reportMu.Lock()
+		report.Errors = append(report.Errors, msg)
+		reportMu.Unlock()
+		return
 	}
+	r.Blacklist()
+	r.Select()
+	r.Singletons()
+	r.Expired()
+
+	reportMu.Lock()
+	report.Candidates = append(report.Candidates, r.Events()...)
+	reportMu.Unlock()
 
-	if len(instances) == 0 {
-		log.Printf("main.go: no instances to delete")
+	if r.Len() == 0 {
+		log.Printf("main.go: project=%s: no %ss to delete", project, r.Kind())
+		return
 	}
 
 	if notDryRun {
-		log.Printf("main.go: issuing parallel instances delete.")
-		err = delete.ParallelInstances(computeSvc, project, workers, instances)
+		log.Printf("main.go: project=%s: issuing parallel %s delete.", project, r.Kind())
+		results, err := delete.Parallel(computeSvc, project, workers, r.Deleters(), qps, burst)
 		if err != nil {
-			log.Fatalf("main.go: deletion exited with an error: %s", err)
+			log.Printf("main.go: project=%s: %s delete finished with errors: %s", project, r.Kind(), err)
+		} else {
+			log.Printf("main.go: project=%s: successfully deleted old %ss", project, r.Kind())
 		}
-		log.Printf("main.go: successfully deleted old instances")
-	}
 
-	wg.Done()
+		reportMu.Lock()
+		for _, result := range results {
+			if result.Err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("project=%s: unable to delete %s %s: %s", project, r.Kind(), result.Name, result.Err))
+				continue
+			}
+			report.Deleted = append(report.Deleted, audit.Event{Kind: r.Kind(), Name: result.Name, Decision: "deleted"})
+		}
+		reportMu.Unlock()
+	}
 }
-
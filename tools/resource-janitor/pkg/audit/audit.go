@@ -0,0 +1,49 @@
+// Package audit builds a structured record of what a sweep did or would
+// have done, for writing to a JSON report file and/or publishing to an
+// audit sink (Cloud Logging or Pub/Sub) so downstream systems can alert
+// on unexpected deletions or feed a compliance pipeline.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Event describes one resource candidate's keep/delete decision at some
+// point in a resource type's pipeline (Blacklist, Select, Singletons,
+// Expired, or the final delete call).
+type Event struct {
+	Kind              string  `json:"kind"`
+	Name              string  `json:"name"`
+	Zone              string  `json:"zone,omitempty"`
+	CreationTimestamp string  `json:"creation_timestamp,omitempty"`
+	AgeSeconds        float64 `json:"age_seconds,omitempty"`
+	Decision          string  `json:"decision"`
+	Reason            string  `json:"reason"`
+}
+
+// Report is the machine-readable document written to --report and/or
+// published to --audit-sink for one sweep of one project.
+type Report struct {
+	RunID      string    `json:"run_id"`
+	Project    string    `json:"project"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Candidates []Event   `json:"candidates"`
+	Deleted    []Event   `json:"deleted"`
+	Errors     []string  `json:"errors"`
+}
+
+// WriteFile marshals report as JSON and writes it to path.
+func (r *Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("audit: unable to marshal report: %w", err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("audit: unable to write report to %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReportWriteFile(t *testing.T) {
+	report := &Report{
+		RunID:     "run-1",
+		Project:   "my-project",
+		StartedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Candidates: []Event{
+			{Kind: "instance", Name: "web-1", Decision: "kept", Reason: "blacklisted"},
+		},
+		Deleted: []Event{
+			{Kind: "instance", Name: "web-2", Decision: "deleted"},
+		},
+		Errors: []string{"unable to delete disk d-1: permission denied"},
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile returned error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read back report: %s", err)
+	}
+
+	var got Report
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unable to unmarshal report: %s", err)
+	}
+	if got.RunID != report.RunID || got.Project != report.Project {
+		t.Errorf("WriteFile round-tripped to %+v, want %+v", got, report)
+	}
+	if len(got.Candidates) != 1 || len(got.Deleted) != 1 || len(got.Errors) != 1 {
+		t.Errorf("WriteFile round-tripped with Candidates=%d Deleted=%d Errors=%d, want 1 each", len(got.Candidates), len(got.Deleted), len(got.Errors))
+	}
+}
+
+func TestParseSinkEmptyExprReturnsNilSink(t *testing.T) {
+	sink, err := ParseSink(context.Background(), "my-project", "")
+	if err != nil {
+		t.Fatalf("ParseSink returned error: %s", err)
+	}
+	if sink != nil {
+		t.Errorf("ParseSink(\"\") = %v, want nil", sink)
+	}
+}
+
+func TestParseSinkUnrecognizedScheme(t *testing.T) {
+	if _, err := ParseSink(context.Background(), "my-project", "s3://bucket"); err == nil {
+		t.Errorf("ParseSink with an unrecognized scheme returned nil error, want non-nil")
+	}
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/pubsub"
+)
+
+// Sink publishes a Report's events to an external system so downstream
+// consumers can alert on unexpected deletions or feed a compliance
+// pipeline.
+type Sink interface {
+	// Publish sends every candidate, deleted, and error event in report.
+	Publish(ctx context.Context, report *Report) error
+}
+
+// ParseSink parses a --audit-sink value. Supported forms are
+// "cloudlogging://<log-name>" and "pubsub://<topic-id>"; an empty expr
+// returns a nil Sink, which callers should treat as "no sink configured".
+func ParseSink(ctx context.Context, project, expr string) (Sink, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	switch {
+	case strings.HasPrefix(expr, "cloudlogging://"):
+		logName := strings.TrimPrefix(expr, "cloudlogging://")
+		client, err := logging.NewClient(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("audit: unable to create Cloud Logging client: %w", err)
+		}
+		return &cloudLoggingSink{logger: client.Logger(logName)}, nil
+
+	case strings.HasPrefix(expr, "pubsub://"):
+		topicID := strings.TrimPrefix(expr, "pubsub://")
+		client, err := pubsub.NewClient(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("audit: unable to create Pub/Sub client: %w", err)
+		}
+		return &pubsubSink{topic: client.Topic(topicID)}, nil
+
+	default:
+		return nil, fmt.Errorf("audit: unrecognized --audit-sink %q, expected a cloudlogging:// or pubsub:// URL", expr)
+	}
+}
+
+type cloudLoggingSink struct {
+	logger *logging.Logger
+}
+
+func (s *cloudLoggingSink) Publish(ctx context.Context, report *Report) error {
+	for _, e := range report.Candidates {
+		s.logger.Log(logging.Entry{Payload: e})
+	}
+	for _, e := range report.Deleted {
+		s.logger.Log(logging.Entry{Payload: e, Severity: logging.Notice})
+	}
+	for _, e := range report.Errors {
+		s.logger.Log(logging.Entry{Payload: e, Severity: logging.Error})
+	}
+	return s.logger.Flush()
+}
+
+type pubsubSink struct {
+	topic *pubsub.Topic
+}
+
+func (s *pubsubSink) Publish(ctx context.Context, report *Report) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("audit: unable to marshal report: %w", err)
+	}
+
+	result := s.topic.Publish(ctx, &pubsub.Message{Data: b})
+	if _, err := result.Get(ctx); err != nil {
+		return fmt.Errorf("audit: unable to publish report: %w", err)
+	}
+	return nil
+}
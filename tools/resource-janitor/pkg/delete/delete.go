@@ -1,95 +1,151 @@
 package delete
 
 import (
+	"context"
 	"fmt"
-	"strings"
-	compute "google.golang.org/api/compute/v1"
 	"log"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	"golang.org/x/time/rate"
+	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
 
-type resourceDelete interface {
-	Do(...googleapi.CallOption) (*compute.Operation, error)
+// ResourceDeleter is implemented by each resource type's per-candidate
+// delete call (pkg/resources) so Parallel can drive any Compute Engine
+// resource type through the same worker pool.
+type ResourceDeleter interface {
+	// Name identifies the resource being deleted, for logging.
+	Name() string
+	// Do issues the delete call and returns the resulting operation.
+	Do() (*compute.Operation, error)
 }
 
-// ParallelImages issues a parallel delete for listed images
-func ParallelImages(computeSvc *compute.Service, project string, workers int, imageList []*compute.Image) error {
-	images := make(chan resourceDelete, workers)
-	var wg sync.WaitGroup
-
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		log.Printf("delete.go: image-worker-%d: starting", i)
-		go deleteWorker(fmt.Sprintf("image-worker-%d", i), computeSvc, project, images, &wg)
-	}
-
-	for _, image := range imageList {
-		images <- computeSvc.Images.Delete(project, image.Name)
-	}
-
-	close(images)
+// Result records the outcome of one ResourceDeleter: Err is nil if the
+// resource was deleted successfully.
+type Result struct {
+	Name string
+	Err  error
+}
 
-	wg.Wait()
-	return nil
+type operationGetter interface {
+	Do(opts ...googleapi.CallOption) (*compute.Operation, error)
 }
 
-// ParallelInstances issues a parallel delete for listed instances
-func ParallelInstances(computeSvc *compute.Service, project string, workers int, instanceList []*compute.Instance) error {
-	instances := make(chan resourceDelete, workers)
+// Parallel issues a parallel delete for the given resource deleters using
+// the supplied Compute service to poll the resulting operations. Delete
+// and poll calls are retried with exponential backoff on rate-limiting
+// and transient server errors, and are throttled to qps requests per
+// second (bursts up to burst) shared across all workers, so a large
+// workers value can't get the caller rate limited project-wide. A
+// per-deleter failure is logged and doesn't abort the rest of the run.
+// Parallel returns one Result per deleter, in no particular order, so the
+// caller can tell exactly which deletes succeeded and which failed even
+// when only some of the batch fails; it also returns a combined error
+// describing every deleter that failed, or nil if all succeeded.
+func Parallel(computeSvc *compute.Service, project string, workers int, deleters []ResourceDeleter, qps float64, burst int) ([]Result, error) {
+	limiter := rate.NewLimiter(rate.Limit(qps), burst)
+	calls := make(chan ResourceDeleter, workers)
+	results := make(chan Result, len(deleters))
 	var wg sync.WaitGroup
 
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		log.Printf("delete.go: instance-worker-%d: Starting", i)
-		go deleteWorker(fmt.Sprintf("delete.go: instance-worker-%d", i), computeSvc, project, instances, &wg)
+		log.Printf("delete.go: worker-%d: starting", i)
+		go deleteWorker(fmt.Sprintf("worker-%d", i), computeSvc, project, calls, results, limiter, &wg)
 	}
 
-	for _, instance := range instanceList {
-		log.Printf("delete.go: Deleting instance: project=%s zone=%s name=%s", project, sanitizeResourceURL(instance.Zone), instance.Name)
-		instances <- computeSvc.Instances.Delete(project, sanitizeResourceURL(instance.Zone), instance.Name)
+	for _, d := range deleters {
+		calls <- d
 	}
-
-	close(instances)
+	close(calls)
 
 	wg.Wait()
-	return nil
+	close(results)
+
+	var all []Result
+	var failures []string
+	for result := range results {
+		all = append(all, result)
+		if result.Err != nil {
+			failures = append(failures, result.Err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return all, fmt.Errorf("delete: %d of %d deletes failed:\n%s", len(failures), len(deleters), strings.Join(failures, "\n"))
+	}
+	return all, nil
 }
 
-func deleteWorker(id string, computeSvc *compute.Service, project string, resourceDeleteCalls <-chan resourceDelete, wg *sync.WaitGroup) {
+func deleteWorker(id string, computeSvc *compute.Service, project string, calls <-chan ResourceDeleter, results chan<- Result, limiter *rate.Limiter, wg *sync.WaitGroup) {
 	defer wg.Done()
-	for call := range resourceDeleteCalls {
-		deleteOperation, err := call.Do()
+	ctx := context.Background()
+	for call := range calls {
+		if err := limiter.Wait(ctx); err != nil {
+			results <- Result{Name: call.Name(), Err: fmt.Errorf("%s: rate limiter: %w", id, err)}
+			continue
+		}
+
+		var deleteOperation *compute.Operation
+		err := retry.Do(func() error {
+			op, err := call.Do()
+			if err != nil {
+				return err
+			}
+			deleteOperation = op
+			return nil
+		})
 		if err != nil {
-			log.Fatalf("%s: Unable to issue delete call %v: %s", id, call, err)
+			results <- Result{Name: call.Name(), Err: fmt.Errorf("%s: unable to issue delete call for %s: %w", id, call.Name(), err)}
+			continue
 		}
-		var queryDeleteOperation resourceDelete
+
+		var queryDeleteOperation operationGetter
 		if deleteOperation.Zone != "" {
-			queryDeleteOperation = computeSvc.ZoneOperations.Get(project, sanitizeResourceURL(deleteOperation.Zone), deleteOperation.Name)
+			queryDeleteOperation = computeSvc.ZoneOperations.Get(project, utils.GetResourceNameFromURL(deleteOperation.Zone), deleteOperation.Name)
 		} else if deleteOperation.Region != "" {
-			queryDeleteOperation = computeSvc.RegionOperations.Get(project, sanitizeResourceURL(deleteOperation.Region), deleteOperation.Name)
+			queryDeleteOperation = computeSvc.RegionOperations.Get(project, utils.GetResourceNameFromURL(deleteOperation.Region), deleteOperation.Name)
 		} else {
 			queryDeleteOperation = computeSvc.GlobalOperations.Get(project, deleteOperation.Name)
 		}
-		for {
-			toSleep, _ := time.ParseDuration("3s")
-			time.Sleep(toSleep)
-			deleteOperation, err = queryDeleteOperation.Do()
-			if err != nil {
-				log.Fatalf("%s: Unable to fetch operation %s: %s", id, deleteOperation.Name, err)
-			}
 
-			if deleteOperation.Status == "DONE" {
-				break
-			}
+		if err := pollOperation(ctx, limiter, queryDeleteOperation, deleteOperation); err != nil {
+			results <- Result{Name: call.Name(), Err: fmt.Errorf("%s: %w", id, err)}
+			continue
 		}
 		log.Printf("delete.go: %s: Deleted resource %s", id, deleteOperation.TargetLink)
+		results <- Result{Name: call.Name()}
 	}
 	log.Printf("delete.go: %s: Stopping", id)
 }
 
-func sanitizeResourceURL(z string) string {
-	splitZone := strings.Split(z, "/")
-	return splitZone[len(splitZone)-1]
+// pollOperation polls op until it reaches the DONE status, retrying
+// individual poll calls with exponential backoff on transient errors.
+func pollOperation(ctx context.Context, limiter *rate.Limiter, queryDeleteOperation operationGetter, op *compute.Operation) error {
+	for op.Status != "DONE" {
+		time.Sleep(3 * time.Second)
+
+		if err := limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limiter: %w", err)
+		}
+
+		var newOp *compute.Operation
+		err := retry.Do(func() error {
+			o, err := queryDeleteOperation.Do()
+			if err != nil {
+				return err
+			}
+			newOp = o
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to fetch operation %s: %w", op.Name, err)
+		}
+		op = newOp
+	}
+	return nil
 }
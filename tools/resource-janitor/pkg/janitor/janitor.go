@@ -0,0 +1,41 @@
+// Package janitor defines the interface every cleanable Compute Engine
+// resource type (pkg/resources) implements, so main.go can drive an
+// arbitrary set of resource types through the same sweep instead of
+// hard-coding a goroutine per type.
+package janitor
+
+import (
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+)
+
+// Resource is implemented by each Compute Engine resource type the janitor
+// knows how to clean up (images, instances, disks, snapshots, addresses,
+// forwarding rules, target pools).
+type Resource interface {
+	// Kind identifies the resource type for logging, e.g. "image".
+	Kind() string
+	// Refresh lists every current instance of this resource type and
+	// populates the candidate list.
+	Refresh() error
+	// Blacklist drops any candidate matching the configured blacklist.
+	Blacklist()
+	// Select drops any candidate whose labels don't satisfy the
+	// configured label selector.
+	Select()
+	// Singletons drops the newest resource in each name-delimited group,
+	// leaving only the non-singleton candidates eligible for deletion.
+	Singletons()
+	// Expired drops any candidate that isn't older than the configured
+	// cutoff.
+	Expired()
+	// Len returns the number of candidates still eligible for deletion.
+	Len() int
+	// Deleters returns a delete.ResourceDeleter for every remaining
+	// candidate.
+	Deleters() []delete.ResourceDeleter
+	// Events returns one audit.Event per candidate considered so far,
+	// recording the keep/drop decision made at each pipeline stage, for
+	// a --report file or --audit-sink.
+	Events() []audit.Event
+}
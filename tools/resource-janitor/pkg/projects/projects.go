@@ -0,0 +1,83 @@
+// Package projects resolves the set of project IDs a sweep should run
+// against, expanding a --folder or --organization node into its active
+// child projects via the Cloud Resource Manager API.
+//
+// Expansion is NOT recursive: only projects whose parent is exactly the
+// given folder or organization are listed. A project nested under a
+// sub-folder of --folder (or under any folder at all, for --organization)
+// is not included; this package does not walk the folder tree to find it.
+// A --folder pointed at a node with sub-folders must be repeated once per
+// sub-folder to cover the whole subtree.
+package projects
+
+import (
+	"fmt"
+	"strings"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// Resolve returns the project IDs a sweep should run against. projectFlag
+// is a comma-separated list of explicit project IDs, as accepted by
+// --project; it is returned as-is when folder and organization are both
+// empty. Otherwise exactly one of folder ("folders/<id>") or organization
+// ("organizations/<id>") is expected, and every ACTIVE project directly
+// parented by that node is listed via the Cloud Resource Manager API. This
+// is one level only: see the package doc comment for why projects nested
+// under sub-folders aren't picked up.
+func Resolve(crmSvc *cloudresourcemanager.Service, projectFlag, folder, organization string) ([]string, error) {
+	if folder == "" && organization == "" {
+		return splitProjectFlag(projectFlag), nil
+	}
+
+	parent := folder
+	if organization != "" {
+		parent = organization
+	}
+	return listActiveProjects(crmSvc, parent)
+}
+
+func splitProjectFlag(projectFlag string) []string {
+	var ids []string
+	for _, id := range strings.Split(projectFlag, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// listActiveProjects lists the IDs of every ACTIVE project whose parent is
+// exactly parent (a "folders/<id>" or "organizations/<id>" resource name),
+// following pagination until exhausted. It does not recurse into
+// sub-folders of parent; a project parented by one of those is not
+// returned.
+func listActiveProjects(crmSvc *cloudresourcemanager.Service, parent string) ([]string, error) {
+	parts := strings.SplitN(parent, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("projects: invalid parent %q, expected \"folders/<id>\" or \"organizations/<id>\"", parent)
+	}
+	parentType, parentID := parts[0], parts[1]
+
+	filter := fmt.Sprintf("lifecycleState:ACTIVE parent.type:%s parent.id:%s", strings.TrimSuffix(parentType, "s"), parentID)
+
+	var ids []string
+	call := crmSvc.Projects.List().Filter(filter)
+	for {
+		resp, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("projects: unable to list projects under %s: %w", parent, err)
+		}
+
+		for _, p := range resp.Projects {
+			ids = append(ids, p.ProjectId)
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(resp.NextPageToken)
+	}
+	return ids, nil
+}
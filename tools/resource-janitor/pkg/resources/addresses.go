@@ -0,0 +1,231 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Addresses implements janitor.Resource for reserved static IP addresses.
+type Addresses struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.Address
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewAddresses creates a new Addresses janitor.Resource.
+func NewAddresses(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *Addresses {
+	return &Addresses{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *Addresses) Kind() string { return "address" }
+
+// Len returns the number of addresses still in the candidate list.
+func (r *Addresses) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with every reserved
+// address not currently in use, newest first within each region.
+func (r *Addresses) Refresh() error {
+	var regions *compute.RegionList
+	err := retry.Do(func() error {
+		rs, err := r.Service.Regions.List(r.Project).Do()
+		if err != nil {
+			return err
+		}
+		regions = rs
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources: unable to list regions: %w", err)
+	}
+
+	var all []*compute.Address
+	for _, region := range regions.Items {
+		call := r.Service.Addresses.List(r.Project, region.Name).OrderBy("creationTimestamp desc")
+		for {
+			var list *compute.AddressList
+			err := retry.Do(func() error {
+				l, err := call.Do()
+				if err != nil {
+					return err
+				}
+				list = l
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("resources: unable to list addresses in region %s: %w", region.Name, err)
+			}
+
+			for _, a := range list.Items {
+				if a.Status == "IN_USE" {
+					continue
+				}
+				log.Printf("resources.go: found address: name=%s region=%s creationTimestamp=%s", a.Name, region.Name, a.CreationTimestamp)
+				all = append(all, a)
+			}
+
+			if list.NextPageToken == "" {
+				break
+			}
+			call = call.PageToken(list.NextPageToken)
+		}
+	}
+	r.Items = all
+	return nil
+}
+
+// Blacklist drops any address whose name matches one of BlacklistPatterns.
+func (r *Addresses) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.Address
+	for _, a := range r.Items {
+		if matchesAny(a.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded address from deletion: name=%s reason=\"blacklisted\"", a.Name)
+			r.recordEvent(a.Name, utils.GetResourceNameFromURL(a.Region), a.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, a)
+	}
+	r.Items = keep
+}
+
+// Select drops any address whose labels don't satisfy Selector.
+func (r *Addresses) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.Address
+	for _, a := range r.Items {
+		if !r.Selector.Matches(a.Labels) {
+			log.Printf("resources.go: excluded address from deletion: name=%s reason=\"does not match selector\"", a.Name)
+			r.recordEvent(a.Name, utils.GetResourceNameFromURL(a.Region), a.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, a)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest address in each group, leaving only
+// non-singleton candidates eligible for deletion. Addresses are grouped
+// by GroupByLabel when set, falling back to the name-delimited prefix.
+// It's a no-op when DeleteSingletons is set, since that flag means the
+// newest (or only) address in a group should remain eligible for
+// Expired to consider rather than being protected here.
+func (r *Addresses) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, a := range r.Items {
+		names[i] = a.Name
+		labels[i] = a.Labels
+		timestamps[i] = a.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.Address
+	for i, a := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected address for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", a.Name, a.CreationTimestamp)
+			nonSingletons = append(nonSingletons, a)
+		} else {
+			log.Printf("resources.go: excluded address from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", a.Name, a.CreationTimestamp)
+			r.recordEvent(a.Name, utils.GetResourceNameFromURL(a.Region), a.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any address that isn't older than ExpiredBefore.
+func (r *Addresses) Expired() {
+	var expired []*compute.Address
+	for _, a := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(a.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for address %s: %s", a.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected address for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", a.Name, a.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(a.Name, utils.GetResourceNameFromURL(a.Region), a.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, a)
+		} else {
+			log.Printf("resources.go: excluded address from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", a.Name, a.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(a.Name, utils.GetResourceNameFromURL(a.Region), a.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining address.
+func (r *Addresses) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, a := range r.Items {
+		deleters = append(deleters, &addressDeleter{service: r.Service, project: r.Project, address: a})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per address considered so far,
+// recording the keep/would_delete decision made at each pipeline stage.
+func (r *Addresses) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one address's keep/drop
+// decision.
+func (r *Addresses) recordEvent(name, region, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		Zone:              region,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+type addressDeleter struct {
+	service *compute.Service
+	project string
+	address *compute.Address
+}
+
+func (d *addressDeleter) Name() string { return d.address.Name }
+
+func (d *addressDeleter) Do() (*compute.Operation, error) {
+	return d.service.Addresses.Delete(d.project, utils.GetResourceNameFromURL(d.address.Region), d.address.Name).Do()
+}
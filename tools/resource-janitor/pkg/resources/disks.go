@@ -0,0 +1,294 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Disks implements janitor.Resource for persistent disks.
+type Disks struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.Disk
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewDisks creates a new Disks janitor.Resource.
+func NewDisks(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *Disks {
+	return &Disks{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *Disks) Kind() string { return "disk" }
+
+// Len returns the number of disks still in the candidate list.
+func (r *Disks) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with every
+// unattached disk. Zones are listed concurrently by a bounded pool of
+// workers pulling zone names off a shared channel and streaming the
+// disks they find onto a shared channel, so Items is no longer
+// guaranteed to come back newest-first the way a single ordered List
+// call would; this is fine since Singletons groups by creation time
+// rather than position.
+//
+// Blacklist and Select are applied as each disk is drained off that
+// channel, so blacklisted or non-matching disks are never buffered into
+// Items in the first place. Singletons and Expired can't be pushed into
+// this same streaming stage: Singletons needs to see every disk in a
+// group before it can tell which one is newest, so it (and the Expired
+// pass that follows it) still runs over the buffered, filtered Items
+// slice once Refresh returns.
+func (r *Disks) Refresh() error {
+	var zones *compute.ZoneList
+	err := retry.Do(func() error {
+		z, err := r.Service.Zones.List(r.Project).Do()
+		if err != nil {
+			return err
+		}
+		zones = z
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources: unable to list zones: %w", err)
+	}
+
+	zoneNames := make(chan string, len(zones.Items))
+	for _, zone := range zones.Items {
+		zoneNames <- zone.Name
+	}
+	close(zoneNames)
+
+	found := make(chan *compute.Disk, zoneListWorkers)
+	errs := make(chan error, len(zones.Items))
+	var wg sync.WaitGroup
+	for i := 0; i < zoneListWorkers; i++ {
+		wg.Add(1)
+		go r.listZone(zoneNames, found, errs, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+		close(errs)
+	}()
+
+	var all []*compute.Disk
+	for d := range found {
+		if matchesAny(d.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded disk from deletion: name=%s reason=\"blacklisted\"", d.Name)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		if r.Selector != nil && !r.Selector.Matches(d.Labels) {
+			log.Printf("resources.go: excluded disk from deletion: name=%s reason=\"does not match selector\"", d.Name)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		all = append(all, d)
+	}
+
+	var failures []string
+	for err := range errs {
+		failures = append(failures, err.Error())
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("resources: %d zone(s) failed to list disks:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	r.Items = all
+	return nil
+}
+
+// listZone lists unattached disks for every zone it receives from
+// zoneNames, streaming each disk onto found and reporting per-zone
+// failures on errs, until zoneNames is closed and drained.
+func (r *Disks) listZone(zoneNames <-chan string, found chan<- *compute.Disk, errs chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for zone := range zoneNames {
+		call := r.Service.Disks.List(r.Project, zone)
+		for {
+			var list *compute.DiskList
+			err := retry.Do(func() error {
+				l, err := call.Do()
+				if err != nil {
+					return err
+				}
+				list = l
+				return nil
+			})
+			if err != nil {
+				errs <- fmt.Errorf("unable to list disks in zone %s: %w", zone, err)
+				break
+			}
+
+			for _, d := range list.Items {
+				if len(d.Users) > 0 {
+					continue
+				}
+				log.Printf("resources.go: found disk: name=%s zone=%s creationTimestamp=%s", d.Name, zone, d.CreationTimestamp)
+				found <- d
+			}
+
+			if list.NextPageToken == "" {
+				break
+			}
+			call = call.PageToken(list.NextPageToken)
+		}
+	}
+}
+
+// Blacklist drops any disk whose name matches one of BlacklistPatterns.
+func (r *Disks) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.Disk
+	for _, d := range r.Items {
+		if matchesAny(d.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded disk from deletion: name=%s reason=\"blacklisted\"", d.Name)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, d)
+	}
+	r.Items = keep
+}
+
+// Select drops any disk whose labels don't satisfy Selector.
+func (r *Disks) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.Disk
+	for _, d := range r.Items {
+		if !r.Selector.Matches(d.Labels) {
+			log.Printf("resources.go: excluded disk from deletion: name=%s reason=\"does not match selector\"", d.Name)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, d)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest disk in each group, leaving only
+// non-singleton candidates eligible for deletion. Disks are grouped by
+// GroupByLabel when set, falling back to the name-delimited prefix. It's
+// a no-op when DeleteSingletons is set, since that flag means the newest
+// (or only) disk in a group should remain eligible for Expired to
+// consider rather than being protected here.
+func (r *Disks) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, d := range r.Items {
+		names[i] = d.Name
+		labels[i] = d.Labels
+		timestamps[i] = d.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.Disk
+	for i, d := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected disk for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", d.Name, d.CreationTimestamp)
+			nonSingletons = append(nonSingletons, d)
+		} else {
+			log.Printf("resources.go: excluded disk from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", d.Name, d.CreationTimestamp)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any disk that isn't older than ExpiredBefore.
+func (r *Disks) Expired() {
+	var expired []*compute.Disk
+	for _, d := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(d.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for disk %s: %s", d.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected disk for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", d.Name, d.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, d)
+		} else {
+			log.Printf("resources.go: excluded disk from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", d.Name, d.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(d.Name, utils.GetResourceNameFromURL(d.Zone), d.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining disk.
+func (r *Disks) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, d := range r.Items {
+		deleters = append(deleters, &diskDeleter{service: r.Service, project: r.Project, disk: d})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per disk considered so far, recording
+// the keep/would_delete decision made at each pipeline stage.
+func (r *Disks) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one disk's keep/drop decision.
+func (r *Disks) recordEvent(name, zone, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		Zone:              zone,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+type diskDeleter struct {
+	service *compute.Service
+	project string
+	disk    *compute.Disk
+}
+
+func (d *diskDeleter) Name() string { return d.disk.Name }
+
+func (d *diskDeleter) Do() (*compute.Operation, error) {
+	return d.service.Disks.Delete(d.project, utils.GetResourceNameFromURL(d.disk.Zone), d.disk.Name).Do()
+}
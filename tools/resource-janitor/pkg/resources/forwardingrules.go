@@ -0,0 +1,232 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// ForwardingRules implements janitor.Resource for regional forwarding
+// rules.
+type ForwardingRules struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.ForwardingRule
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewForwardingRules creates a new ForwardingRules janitor.Resource.
+func NewForwardingRules(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *ForwardingRules {
+	return &ForwardingRules{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *ForwardingRules) Kind() string { return "forwarding_rule" }
+
+// Len returns the number of forwarding rules still in the candidate list.
+func (r *ForwardingRules) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with every current
+// forwarding rule, newest first within each region.
+func (r *ForwardingRules) Refresh() error {
+	var regions *compute.RegionList
+	err := retry.Do(func() error {
+		rs, err := r.Service.Regions.List(r.Project).Do()
+		if err != nil {
+			return err
+		}
+		regions = rs
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources: unable to list regions: %w", err)
+	}
+
+	var all []*compute.ForwardingRule
+	for _, region := range regions.Items {
+		call := r.Service.ForwardingRules.List(r.Project, region.Name).OrderBy("creationTimestamp desc")
+		for {
+			var list *compute.ForwardingRuleList
+			err := retry.Do(func() error {
+				l, err := call.Do()
+				if err != nil {
+					return err
+				}
+				list = l
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("resources: unable to list forwarding rules in region %s: %w", region.Name, err)
+			}
+
+			for _, fr := range list.Items {
+				log.Printf("resources.go: found forwarding rule: name=%s region=%s creationTimestamp=%s", fr.Name, region.Name, fr.CreationTimestamp)
+				all = append(all, fr)
+			}
+
+			if list.NextPageToken == "" {
+				break
+			}
+			call = call.PageToken(list.NextPageToken)
+		}
+	}
+	r.Items = all
+	return nil
+}
+
+// Blacklist drops any forwarding rule whose name matches one of
+// BlacklistPatterns.
+func (r *ForwardingRules) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.ForwardingRule
+	for _, fr := range r.Items {
+		if matchesAny(fr.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded forwarding rule from deletion: name=%s reason=\"blacklisted\"", fr.Name)
+			r.recordEvent(fr.Name, utils.GetResourceNameFromURL(fr.Region), fr.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, fr)
+	}
+	r.Items = keep
+}
+
+// Select drops any forwarding rule whose labels don't satisfy Selector.
+func (r *ForwardingRules) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.ForwardingRule
+	for _, fr := range r.Items {
+		if !r.Selector.Matches(fr.Labels) {
+			log.Printf("resources.go: excluded forwarding rule from deletion: name=%s reason=\"does not match selector\"", fr.Name)
+			r.recordEvent(fr.Name, utils.GetResourceNameFromURL(fr.Region), fr.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, fr)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest forwarding rule in each group, leaving
+// only non-singleton candidates eligible for deletion. Forwarding rules
+// are grouped by GroupByLabel when set, falling back to the
+// name-delimited prefix. It's a no-op when DeleteSingletons is set,
+// since that flag means the newest (or only) forwarding rule in a group
+// should remain eligible for Expired to consider rather than being
+// protected here.
+func (r *ForwardingRules) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, fr := range r.Items {
+		names[i] = fr.Name
+		labels[i] = fr.Labels
+		timestamps[i] = fr.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.ForwardingRule
+	for i, fr := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected forwarding rule for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", fr.Name, fr.CreationTimestamp)
+			nonSingletons = append(nonSingletons, fr)
+		} else {
+			log.Printf("resources.go: excluded forwarding rule from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", fr.Name, fr.CreationTimestamp)
+			r.recordEvent(fr.Name, utils.GetResourceNameFromURL(fr.Region), fr.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any forwarding rule that isn't older than ExpiredBefore.
+func (r *ForwardingRules) Expired() {
+	var expired []*compute.ForwardingRule
+	for _, fr := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(fr.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for forwarding rule %s: %s", fr.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected forwarding rule for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", fr.Name, fr.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(fr.Name, utils.GetResourceNameFromURL(fr.Region), fr.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, fr)
+		} else {
+			log.Printf("resources.go: excluded forwarding rule from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", fr.Name, fr.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(fr.Name, utils.GetResourceNameFromURL(fr.Region), fr.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining forwarding
+// rule.
+func (r *ForwardingRules) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, fr := range r.Items {
+		deleters = append(deleters, &forwardingRuleDeleter{service: r.Service, project: r.Project, forwardingRule: fr})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per forwarding rule considered so far,
+// recording the keep/would_delete decision made at each pipeline stage.
+func (r *ForwardingRules) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one forwarding rule's keep/drop
+// decision.
+func (r *ForwardingRules) recordEvent(name, region, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		Zone:              region,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+type forwardingRuleDeleter struct {
+	service        *compute.Service
+	project        string
+	forwardingRule *compute.ForwardingRule
+}
+
+func (d *forwardingRuleDeleter) Name() string { return d.forwardingRule.Name }
+
+func (d *forwardingRuleDeleter) Do() (*compute.Operation, error) {
+	return d.service.ForwardingRules.Delete(d.project, utils.GetResourceNameFromURL(d.forwardingRule.Region), d.forwardingRule.Name).Do()
+}
@@ -0,0 +1,251 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Images implements janitor.Resource for Compute Engine images.
+type Images struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.Image
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewImages creates a new Images janitor.Resource.
+func NewImages(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *Images {
+	return &Images{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *Images) Kind() string { return "image" }
+
+// Len returns the number of images still in the candidate list.
+func (r *Images) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with all current
+// images, newest first.
+func (r *Images) Refresh() error {
+	call := r.Service.Images.List(r.Project).OrderBy("creationTimestamp desc")
+	var all []*compute.Image
+	for {
+		var list *compute.ImageList
+		err := retry.Do(func() error {
+			l, err := call.Do()
+			if err != nil {
+				return err
+			}
+			list = l
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("resources: unable to list images: %w", err)
+		}
+
+		for _, im := range list.Items {
+			log.Printf("resources.go: found image: name=%s creationTimestamp=%s", im.Name, im.CreationTimestamp)
+			all = append(all, im)
+		}
+
+		if list.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(list.NextPageToken)
+	}
+	r.Items = all
+	return nil
+}
+
+// Blacklist drops any image whose name matches one of BlacklistPatterns.
+func (r *Images) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.Image
+	for _, im := range r.Items {
+		if matchesAny(im.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded image from deletion: name=%s reason=\"blacklisted\"", im.Name)
+			r.recordEvent(im.Name, im.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, im)
+	}
+	r.Items = keep
+}
+
+// Select drops any image whose labels don't satisfy Selector.
+func (r *Images) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.Image
+	for _, im := range r.Items {
+		if !r.Selector.Matches(im.Labels) {
+			log.Printf("resources.go: excluded image from deletion: name=%s reason=\"does not match selector\"", im.Name)
+			r.recordEvent(im.Name, im.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, im)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest image in each group, leaving only
+// non-singleton candidates eligible for deletion. Images are grouped by
+// GroupByLabel when set, falling back to the name-delimited prefix. It's
+// a no-op when DeleteSingletons is set, since that flag means the newest
+// (or only) image in a group should remain eligible for Expired to
+// consider rather than being protected here.
+func (r *Images) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, im := range r.Items {
+		names[i] = im.Name
+		labels[i] = im.Labels
+		timestamps[i] = im.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.Image
+	for i, im := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected image for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", im.Name, im.CreationTimestamp)
+			nonSingletons = append(nonSingletons, im)
+		} else {
+			log.Printf("resources.go: excluded image from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", im.Name, im.CreationTimestamp)
+			r.recordEvent(im.Name, im.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any image that isn't older than ExpiredBefore.
+func (r *Images) Expired() {
+	var expired []*compute.Image
+	for _, im := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(im.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for image %s: %s", im.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected image for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", im.Name, im.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(im.Name, im.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, im)
+		} else {
+			log.Printf("resources.go: excluded image from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", im.Name, im.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(im.Name, im.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Events returns one audit.Event per image considered so far, recording
+// the keep/would_delete decision made at each pipeline stage.
+func (r *Images) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one image's keep/drop decision.
+func (r *Images) recordEvent(name, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining image.
+func (r *Images) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, im := range r.Items {
+		deleters = append(deleters, &imageDeleter{service: r.Service, project: r.Project, image: im})
+	}
+	return deleters
+}
+
+type imageDeleter struct {
+	service *compute.Service
+	project string
+	image   *compute.Image
+}
+
+func (d *imageDeleter) Name() string { return d.image.Name }
+
+func (d *imageDeleter) Do() (*compute.Operation, error) {
+	return d.service.Images.Delete(d.project, d.image.Name).Do()
+}
+
+// matchesAny reports whether name matches any of the given regular
+// expressions.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if regexp.MustCompile(p).MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// creationTimes parses each Compute API creationTimestamp string into a
+// time.Time for use by the singleton heuristic. A resource whose
+// timestamp fails to parse is treated as created at the zero time, so it
+// is never mistaken for the newest in its group.
+func creationTimes(timestamps []string) []time.Time {
+	times := make([]time.Time, len(timestamps))
+	for i, ts := range timestamps {
+		t, err := utils.ParseCreationTimestamp(ts)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp %q: %s", ts, err)
+			continue
+		}
+		times[i] = t
+	}
+	return times
+}
+
+// ageSeconds reports how old a resource is, given its creationTimestamp
+// string, for use in audit events. It returns 0 if the timestamp fails
+// to parse.
+func ageSeconds(creationTimestamp string) float64 {
+	stamp, err := utils.ParseCreationTimestamp(creationTimestamp)
+	if err != nil {
+		return 0
+	}
+	return time.Since(stamp).Seconds()
+}
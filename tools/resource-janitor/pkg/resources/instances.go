@@ -0,0 +1,297 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// zoneListWorkers bounds how many zones are listed concurrently by Refresh
+// for zonal resource types. Large projects can have on the order of a
+// hundred zones, and listing them one at a time makes Refresh the
+// slowest stage of a sweep.
+const zoneListWorkers = 8
+
+// Instances implements janitor.Resource for Compute Engine instances.
+type Instances struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.Instance
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewInstances creates a new Instances janitor.Resource.
+func NewInstances(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *Instances {
+	return &Instances{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *Instances) Kind() string { return "instance" }
+
+// Len returns the number of instances still in the candidate list.
+func (r *Instances) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with all current
+// instances. Zones are listed concurrently by a bounded pool of workers
+// pulling zone names off a shared channel and streaming the instances
+// they find onto a shared channel, so Items is no longer guaranteed to
+// come back newest-first the way a single ordered List call would; this
+// is fine since Singletons groups by creation time rather than position.
+//
+// Blacklist and Select are applied as each instance is drained off that
+// channel, so blacklisted or non-matching instances are never buffered
+// into Items in the first place. Singletons and Expired can't be pushed
+// into this same streaming stage: Singletons needs to see every instance
+// in a group before it can tell which one is newest, so it (and the
+// Expired pass that follows it) still runs over the buffered, filtered
+// Items slice once Refresh returns.
+func (r *Instances) Refresh() error {
+	var zones *compute.ZoneList
+	err := retry.Do(func() error {
+		z, err := r.Service.Zones.List(r.Project).Do()
+		if err != nil {
+			return err
+		}
+		zones = z
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources: unable to list zones: %w", err)
+	}
+
+	zoneNames := make(chan string, len(zones.Items))
+	for _, zone := range zones.Items {
+		zoneNames <- zone.Name
+	}
+	close(zoneNames)
+
+	found := make(chan *compute.Instance, zoneListWorkers)
+	errs := make(chan error, len(zones.Items))
+	var wg sync.WaitGroup
+	for i := 0; i < zoneListWorkers; i++ {
+		wg.Add(1)
+		go r.listZone(zoneNames, found, errs, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+		close(errs)
+	}()
+
+	var all []*compute.Instance
+	for in := range found {
+		if matchesAny(in.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded instance from deletion: name=%s reason=\"blacklisted\"", in.Name)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		if r.Selector != nil && !r.Selector.Matches(in.Labels) {
+			log.Printf("resources.go: excluded instance from deletion: name=%s reason=\"does not match selector\"", in.Name)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		all = append(all, in)
+	}
+
+	var failures []string
+	for err := range errs {
+		failures = append(failures, err.Error())
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("resources: %d zone(s) failed to list instances:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	r.Items = all
+	return nil
+}
+
+// listZone lists instances for every zone it receives from zoneNames,
+// streaming each instance onto found and reporting per-zone failures on
+// errs, until zoneNames is closed and drained.
+func (r *Instances) listZone(zoneNames <-chan string, found chan<- *compute.Instance, errs chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for zone := range zoneNames {
+		call := r.Service.Instances.List(r.Project, zone)
+		for {
+			var list *compute.InstanceList
+			err := retry.Do(func() error {
+				l, err := call.Do()
+				if err != nil {
+					return err
+				}
+				list = l
+				return nil
+			})
+			if err != nil {
+				errs <- fmt.Errorf("unable to list instances in zone %s: %w", zone, err)
+				break
+			}
+
+			for _, in := range list.Items {
+				log.Printf("resources.go: found instance: name=%s zone=%s creationTimestamp=%s", in.Name, zone, in.CreationTimestamp)
+				found <- in
+			}
+
+			if list.NextPageToken == "" {
+				break
+			}
+			call = call.PageToken(list.NextPageToken)
+		}
+	}
+}
+
+// Blacklist drops any instance whose name matches one of BlacklistPatterns.
+func (r *Instances) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.Instance
+	for _, in := range r.Items {
+		if matchesAny(in.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded instance from deletion: name=%s reason=\"blacklisted\"", in.Name)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, in)
+	}
+	r.Items = keep
+}
+
+// Select drops any instance whose labels don't satisfy Selector.
+func (r *Instances) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.Instance
+	for _, in := range r.Items {
+		if !r.Selector.Matches(in.Labels) {
+			log.Printf("resources.go: excluded instance from deletion: name=%s reason=\"does not match selector\"", in.Name)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, in)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest instance in each group, leaving only
+// non-singleton candidates eligible for deletion. Instances are grouped
+// by GroupByLabel when set, falling back to the name-delimited prefix.
+// It's a no-op when DeleteSingletons is set, since that flag means the
+// newest (or only) instance in a group should remain eligible for
+// Expired to consider rather than being protected here.
+func (r *Instances) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, in := range r.Items {
+		names[i] = in.Name
+		labels[i] = in.Labels
+		timestamps[i] = in.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.Instance
+	for i, in := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected instance for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", in.Name, in.CreationTimestamp)
+			nonSingletons = append(nonSingletons, in)
+		} else {
+			log.Printf("resources.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", in.Name, in.CreationTimestamp)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any instance that isn't older than ExpiredBefore.
+func (r *Instances) Expired() {
+	var expired []*compute.Instance
+	for _, in := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(in.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for instance %s: %s", in.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected instance for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", in.Name, in.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, in)
+		} else {
+			log.Printf("resources.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", in.Name, in.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(in.Name, utils.GetResourceNameFromURL(in.Zone), in.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining instance.
+func (r *Instances) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, in := range r.Items {
+		deleters = append(deleters, &instanceDeleter{service: r.Service, project: r.Project, instance: in})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per instance considered so far,
+// recording the keep/would_delete decision made at each pipeline stage.
+func (r *Instances) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one instance's keep/drop
+// decision.
+func (r *Instances) recordEvent(name, zone, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		Zone:              zone,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+type instanceDeleter struct {
+	service  *compute.Service
+	project  string
+	instance *compute.Instance
+}
+
+func (d *instanceDeleter) Name() string { return d.instance.Name }
+
+func (d *instanceDeleter) Do() (*compute.Operation, error) {
+	return d.service.Instances.Delete(d.project, utils.GetResourceNameFromURL(d.instance.Zone), d.instance.Name).Do()
+}
@@ -0,0 +1,212 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Snapshots implements janitor.Resource for persistent disk snapshots.
+type Snapshots struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.Snapshot
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewSnapshots creates a new Snapshots janitor.Resource.
+func NewSnapshots(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *Snapshots {
+	return &Snapshots{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *Snapshots) Kind() string { return "snapshot" }
+
+// Len returns the number of snapshots still in the candidate list.
+func (r *Snapshots) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with every current
+// snapshot, newest first.
+func (r *Snapshots) Refresh() error {
+	call := r.Service.Snapshots.List(r.Project).OrderBy("creationTimestamp desc")
+	var all []*compute.Snapshot
+	for {
+		var list *compute.SnapshotList
+		err := retry.Do(func() error {
+			l, err := call.Do()
+			if err != nil {
+				return err
+			}
+			list = l
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("resources: unable to list snapshots: %w", err)
+		}
+
+		for _, s := range list.Items {
+			log.Printf("resources.go: found snapshot: name=%s creationTimestamp=%s", s.Name, s.CreationTimestamp)
+			all = append(all, s)
+		}
+
+		if list.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(list.NextPageToken)
+	}
+	r.Items = all
+	return nil
+}
+
+// Blacklist drops any snapshot whose name matches one of BlacklistPatterns.
+func (r *Snapshots) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.Snapshot
+	for _, s := range r.Items {
+		if matchesAny(s.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded snapshot from deletion: name=%s reason=\"blacklisted\"", s.Name)
+			r.recordEvent(s.Name, s.CreationTimestamp, "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, s)
+	}
+	r.Items = keep
+}
+
+// Select drops any snapshot whose labels don't satisfy Selector.
+func (r *Snapshots) Select() {
+	if r.Selector == nil {
+		return
+	}
+
+	var keep []*compute.Snapshot
+	for _, s := range r.Items {
+		if !r.Selector.Matches(s.Labels) {
+			log.Printf("resources.go: excluded snapshot from deletion: name=%s reason=\"does not match selector\"", s.Name)
+			r.recordEvent(s.Name, s.CreationTimestamp, "kept", "does not match selector")
+			continue
+		}
+		keep = append(keep, s)
+	}
+	r.Items = keep
+}
+
+// Singletons drops the newest snapshot in each group, leaving only
+// non-singleton candidates eligible for deletion. Snapshots are grouped
+// by GroupByLabel when set, falling back to the name-delimited prefix.
+// It's a no-op when DeleteSingletons is set, since that flag means the
+// newest (or only) snapshot in a group should remain eligible for
+// Expired to consider rather than being protected here.
+func (r *Snapshots) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	labels := make([]map[string]string, len(r.Items))
+	timestamps := make([]string, len(r.Items))
+	for i, s := range r.Items {
+		names[i] = s.Name
+		labels[i] = s.Labels
+		timestamps[i] = s.CreationTimestamp
+	}
+	groupKeys := utils.GroupKeys(names, labels, r.GroupByLabel, r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, creationTimes(timestamps))
+
+	var nonSingletons []*compute.Snapshot
+	for i, s := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected snapshot for deletion: name=%s creationTimestamp=%s reason=\"not the most recent of its type\"", s.Name, s.CreationTimestamp)
+			nonSingletons = append(nonSingletons, s)
+		} else {
+			log.Printf("resources.go: excluded snapshot from deletion: name=%s creationTimestamp=%s reason=\"most recent of its type\"", s.Name, s.CreationTimestamp)
+			r.recordEvent(s.Name, s.CreationTimestamp, "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired drops any snapshot that isn't older than ExpiredBefore.
+func (r *Snapshots) Expired() {
+	var expired []*compute.Snapshot
+	for _, s := range r.Items {
+		stamp, err := utils.ParseCreationTimestamp(s.CreationTimestamp)
+		if err != nil {
+			log.Printf("resources.go: failed to parse timestamp for snapshot %s: %s", s.Name, err)
+			continue
+		}
+
+		if stamp.Before(r.ExpiredBefore) {
+			log.Printf("resources.go: selected snapshot for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", s.Name, s.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(s.Name, s.CreationTimestamp, "would_delete", fmt.Sprintf("older than %s", r.ExpiredBefore))
+			expired = append(expired, s)
+		} else {
+			log.Printf("resources.go: excluded snapshot from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", s.Name, s.CreationTimestamp, r.ExpiredBefore)
+			r.recordEvent(s.Name, s.CreationTimestamp, "kept", fmt.Sprintf("newer than %s", r.ExpiredBefore))
+		}
+	}
+	r.Items = expired
+}
+
+// Deleters returns a delete.ResourceDeleter for every remaining snapshot.
+func (r *Snapshots) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, s := range r.Items {
+		deleters = append(deleters, &snapshotDeleter{service: r.Service, project: r.Project, snapshot: s})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per snapshot considered so far,
+// recording the keep/would_delete decision made at each pipeline stage.
+func (r *Snapshots) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one snapshot's keep/drop
+// decision.
+func (r *Snapshots) recordEvent(name, creationTimestamp, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:              r.Kind(),
+		Name:              name,
+		CreationTimestamp: creationTimestamp,
+		AgeSeconds:        ageSeconds(creationTimestamp),
+		Decision:          decision,
+		Reason:            reason,
+	})
+}
+
+type snapshotDeleter struct {
+	service  *compute.Service
+	project  string
+	snapshot *compute.Snapshot
+}
+
+func (d *snapshotDeleter) Name() string { return d.snapshot.Name }
+
+func (d *snapshotDeleter) Do() (*compute.Operation, error) {
+	return d.service.Snapshots.Delete(d.project, d.snapshot.Name).Do()
+}
@@ -0,0 +1,203 @@
+package resources
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/audit"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/delete"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/retry"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/selector"
+	"github.com/GoogleCloudPlatform/professional-services/tools/resource-janitor/pkg/utils"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// TargetPools implements janitor.Resource for regional network load
+// balancer target pools.
+type TargetPools struct {
+	Service           *compute.Service
+	Project           string
+	Items             []*compute.TargetPool
+	ExpiredBefore     time.Time
+	DeleteSingletons  bool
+	BlacklistPatterns []string
+	NameDelimiter     string
+	Selector          selector.Selector
+	GroupByLabel      string
+	events            []audit.Event
+}
+
+// NewTargetPools creates a new TargetPools janitor.Resource.
+func NewTargetPools(s *compute.Service, project string, expiredBefore time.Time, deleteSingletons bool, blacklist []string, nameDelimiter string, sel selector.Selector, groupByLabel string) *TargetPools {
+	return &TargetPools{
+		Service:           s,
+		Project:           project,
+		ExpiredBefore:     expiredBefore,
+		DeleteSingletons:  deleteSingletons,
+		BlacklistPatterns: blacklist,
+		NameDelimiter:     nameDelimiter,
+		Selector:          sel,
+		GroupByLabel:      groupByLabel,
+	}
+}
+
+// Kind identifies this resource type for logging.
+func (r *TargetPools) Kind() string { return "target_pool" }
+
+// Len returns the number of target pools still in the candidate list.
+func (r *TargetPools) Len() int { return len(r.Items) }
+
+// Refresh queries the Compute API and populates Items with every current
+// target pool.
+//
+// TargetPool has no CreationTimestamp field, so Refresh can't request
+// server-side ordering the way the other resource types do; Items is left
+// in whatever order the API returns.
+func (r *TargetPools) Refresh() error {
+	var regions *compute.RegionList
+	err := retry.Do(func() error {
+		rs, err := r.Service.Regions.List(r.Project).Do()
+		if err != nil {
+			return err
+		}
+		regions = rs
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("resources: unable to list regions: %w", err)
+	}
+
+	var all []*compute.TargetPool
+	for _, region := range regions.Items {
+		call := r.Service.TargetPools.List(r.Project, region.Name)
+		for {
+			var list *compute.TargetPoolList
+			err := retry.Do(func() error {
+				l, err := call.Do()
+				if err != nil {
+					return err
+				}
+				list = l
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("resources: unable to list target pools in region %s: %w", region.Name, err)
+			}
+
+			for _, tp := range list.Items {
+				log.Printf("resources.go: found target pool: name=%s region=%s", tp.Name, region.Name)
+				all = append(all, tp)
+			}
+
+			if list.NextPageToken == "" {
+				break
+			}
+			call = call.PageToken(list.NextPageToken)
+		}
+	}
+	r.Items = all
+	return nil
+}
+
+// Blacklist drops any target pool whose name matches one of
+// BlacklistPatterns.
+func (r *TargetPools) Blacklist() {
+	if len(r.BlacklistPatterns) == 0 {
+		return
+	}
+
+	var keep []*compute.TargetPool
+	for _, tp := range r.Items {
+		if matchesAny(tp.Name, r.BlacklistPatterns) {
+			log.Printf("resources.go: excluded target pool from deletion: name=%s reason=\"blacklisted\"", tp.Name)
+			r.recordEvent(tp.Name, utils.GetResourceNameFromURL(tp.Region), "kept", "blacklisted")
+			continue
+		}
+		keep = append(keep, tp)
+	}
+	r.Items = keep
+}
+
+// Select is a no-op for target pools: the Compute API doesn't expose
+// labels for them, so there's nothing for Selector to match against.
+func (r *TargetPools) Select() {}
+
+// Singletons drops the newest target pool in each name-delimited group,
+// leaving only non-singleton candidates eligible for deletion.
+// GroupByLabel has no effect here, since target pools carry no labels.
+// TargetPool also has no creation timestamp, so "newest" is whichever
+// pool the API happened to return first for its group. It's a no-op
+// when DeleteSingletons is set, since that flag means the newest (or
+// only) target pool in a group should remain eligible for deletion
+// rather than being protected here.
+func (r *TargetPools) Singletons() {
+	if r.DeleteSingletons {
+		return
+	}
+
+	names := make([]string, len(r.Items))
+	for i, tp := range r.Items {
+		names[i] = tp.Name
+	}
+	groupKeys := utils.GroupKeys(names, nil, "", r.NameDelimiter)
+	eligible := utils.NonSingletonIndices(groupKeys, make([]time.Time, len(names)))
+
+	var nonSingletons []*compute.TargetPool
+	for i, tp := range r.Items {
+		if eligible[i] {
+			log.Printf("resources.go: selected target pool for deletion: name=%s reason=\"not the most recent of its type\"", tp.Name)
+			r.recordEvent(tp.Name, utils.GetResourceNameFromURL(tp.Region), "would_delete", "not the most recent of its type")
+			nonSingletons = append(nonSingletons, tp)
+		} else {
+			log.Printf("resources.go: excluded target pool from deletion: name=%s reason=\"most recent of its type\"", tp.Name)
+			r.recordEvent(tp.Name, utils.GetResourceNameFromURL(tp.Region), "kept", "most recent of its type")
+		}
+	}
+	r.Items = nonSingletons
+}
+
+// Expired is a no-op for target pools: the Compute API doesn't expose a
+// creation timestamp for them, so age-based filtering isn't possible and
+// every surviving candidate from Singletons/Blacklist is left as-is.
+func (r *TargetPools) Expired() {}
+
+// Deleters returns a delete.ResourceDeleter for every remaining target
+// pool.
+func (r *TargetPools) Deleters() []delete.ResourceDeleter {
+	var deleters []delete.ResourceDeleter
+	for _, tp := range r.Items {
+		deleters = append(deleters, &targetPoolDeleter{service: r.Service, project: r.Project, targetPool: tp})
+	}
+	return deleters
+}
+
+// Events returns one audit.Event per target pool considered so far,
+// recording the keep/would_delete decision made at each pipeline stage.
+// TargetPool has no creation timestamp, so every event's AgeSeconds is
+// left at zero.
+func (r *TargetPools) Events() []audit.Event { return r.events }
+
+// recordEvent appends an audit.Event for one target pool's keep/drop
+// decision.
+func (r *TargetPools) recordEvent(name, region, decision, reason string) {
+	r.events = append(r.events, audit.Event{
+		Kind:     r.Kind(),
+		Name:     name,
+		Zone:     region,
+		Decision: decision,
+		Reason:   reason,
+	})
+}
+
+type targetPoolDeleter struct {
+	service    *compute.Service
+	project    string
+	targetPool *compute.TargetPool
+}
+
+func (d *targetPoolDeleter) Name() string { return d.targetPool.Name }
+
+func (d *targetPoolDeleter) Do() (*compute.Operation, error) {
+	return d.service.TargetPools.Delete(d.project, utils.GetResourceNameFromURL(d.targetPool.Region), d.targetPool.Name).Do()
+}
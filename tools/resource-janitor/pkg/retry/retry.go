@@ -0,0 +1,86 @@
+// Package retry wraps Compute API calls with exponential backoff so
+// routine 429/5xx responses don't abort a run.
+package retry
+
+import (
+	"math/rand"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Backoff bounds applied between retries. The interval starts at
+// initialBackoff and doubles (with jitter) after every retryable error,
+// capping at maxBackoff.
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+	multiplier     = 2.0
+	maxAttempts    = 8
+)
+
+// retryableStatusCodes are the googleapi.Error codes worth retrying: rate
+// limiting and transient server errors.
+var retryableStatusCodes = map[int]bool{
+	429: true,
+	500: true,
+	502: true,
+	503: true,
+	504: true,
+}
+
+// retryableReasons are googleapi.Error reasons worth retrying even when
+// they arrive with a non-retryable status code.
+var retryableReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// Retryable reports whether err is a googleapi.Error worth retrying.
+func Retryable(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	if retryableStatusCodes[gerr.Code] {
+		return true
+	}
+	for _, e := range gerr.Errors {
+		if retryableReasons[e.Reason] {
+			return true
+		}
+	}
+	return false
+}
+
+// Next doubles d, caps it at maxBackoff, and applies up to 50% jitter so
+// that many callers retrying in lockstep don't all retry at once.
+func Next(d time.Duration) time.Duration {
+	d = time.Duration(float64(d) * multiplier)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/2 + 1))
+	return d/2 + jitter
+}
+
+// Do calls fn, retrying with exponential backoff and jitter whenever fn
+// returns a Retryable error, up to maxAttempts total attempts. It returns
+// the last error seen if fn never succeeds, or immediately on a
+// non-retryable error.
+func Do(fn func() error) error {
+	backoff := initialBackoff
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !Retryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff = Next(backoff)
+	}
+	return err
+}
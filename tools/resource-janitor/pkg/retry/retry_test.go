@@ -0,0 +1,93 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil error", nil, false},
+		{"non-googleapi error", errors.New("boom"), false},
+		{"429 is retryable", &googleapi.Error{Code: 429}, true},
+		{"503 is retryable", &googleapi.Error{Code: 503}, true},
+		{"404 is not retryable", &googleapi.Error{Code: 404}, false},
+		{
+			name: "rateLimitExceeded reason is retryable regardless of code",
+			err: &googleapi.Error{
+				Code:   403,
+				Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}},
+			},
+			want: true,
+		},
+		{
+			name: "unrelated reason with non-retryable code is not retryable",
+			err: &googleapi.Error{
+				Code:   403,
+				Errors: []googleapi.ErrorItem{{Reason: "forbidden"}},
+			},
+			want: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Retryable(c.err); got != c.want {
+				t.Errorf("Retryable(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNextCapsAtMaxBackoff(t *testing.T) {
+	d := maxBackoff
+	for i := 0; i < 5; i++ {
+		d = Next(d)
+		if d > maxBackoff {
+			t.Fatalf("Next(%s) = %s, want <= maxBackoff (%s)", maxBackoff, d, maxBackoff)
+		}
+	}
+}
+
+func TestNextGrowsBelowCap(t *testing.T) {
+	small := 1 * time.Millisecond
+	if got := Next(small); got < small || got > 2*small {
+		t.Errorf("Next(%s) = %s, want between %s and %s", small, got, small, 2*small)
+	}
+}
+
+func TestDoSucceedsWithoutRetrying(t *testing.T) {
+	calls := 0
+	err := Do(func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned error: %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("Do called fn %d times, want 1", calls)
+	}
+}
+
+func TestDoReturnsImmediatelyOnNonRetryableError(t *testing.T) {
+	wantErr := errors.New("permanent failure")
+	calls := 0
+	err := Do(func() error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Errorf("Do returned %v, want %v", err, wantErr)
+	}
+	if calls != 1 {
+		t.Errorf("Do called fn %d times, want 1 for a non-retryable error", calls)
+	}
+}
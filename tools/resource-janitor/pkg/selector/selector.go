@@ -0,0 +1,150 @@
+// Package selector parses Kubernetes-style label selector expressions
+// (e.g. "env=dev,tier!=prod,owner in (alice,bob)") and matches them
+// against a Compute Engine resource's Labels.
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector reports whether a resource's labels satisfy a requirement
+// expression.
+type Selector interface {
+	Matches(labels map[string]string) bool
+}
+
+// Parse parses a comma-separated label selector expression into a
+// Selector. Supported terms are "key", "!key", "key=value", "key!=value",
+// "key in (v1,v2)", and "key notin (v1,v2)"; all terms must match
+// (logical AND). An empty expression returns a Selector that matches
+// everything.
+func Parse(expr string) (Selector, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return matchAll{}, nil
+	}
+
+	var reqs requirements
+	for _, term := range splitRequirements(expr) {
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("selector: unable to parse %q: %w", term, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+type matchAll struct{}
+
+func (matchAll) Matches(map[string]string) bool { return true }
+
+type operator int
+
+const (
+	opExists operator = iota
+	opNotExists
+	opEquals
+	opNotEquals
+	opIn
+	opNotIn
+)
+
+type requirement struct {
+	key    string
+	op     operator
+	values map[string]bool
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.op {
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	case opEquals, opIn:
+		return ok && r.values[v]
+	case opNotEquals, opNotIn:
+		return !ok || !r.values[v]
+	default:
+		return false
+	}
+}
+
+type requirements []requirement
+
+func (rs requirements) Matches(labels map[string]string) bool {
+	for _, r := range rs {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRequirements splits expr on top-level commas, ignoring commas
+// inside a "in (...)"/"notin (...)" value list.
+func splitRequirements(expr string) []string {
+	var terms []string
+	depth := 0
+	start := 0
+	for i, r := range expr {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, strings.TrimSpace(expr[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, strings.TrimSpace(expr[start:]))
+	return terms
+}
+
+func parseRequirement(term string) (requirement, error) {
+	switch {
+	case strings.HasPrefix(term, "!"):
+		return requirement{key: strings.TrimSpace(term[1:]), op: opNotExists}, nil
+	case strings.Contains(term, " notin "):
+		return parseSetRequirement(term, " notin ", opNotIn)
+	case strings.Contains(term, " in "):
+		return parseSetRequirement(term, " in ", opIn)
+	case strings.Contains(term, "!="):
+		parts := strings.SplitN(term, "!=", 2)
+		return requirement{key: strings.TrimSpace(parts[0]), op: opNotEquals, values: toSet(parts[1])}, nil
+	case strings.Contains(term, "="):
+		parts := strings.SplitN(term, "=", 2)
+		return requirement{key: strings.TrimSpace(parts[0]), op: opEquals, values: toSet(parts[1])}, nil
+	case strings.TrimSpace(term) != "":
+		return requirement{key: strings.TrimSpace(term), op: opExists}, nil
+	default:
+		return requirement{}, fmt.Errorf("empty requirement")
+	}
+}
+
+func parseSetRequirement(term, sep string, op operator) (requirement, error) {
+	parts := strings.SplitN(term, sep, 2)
+	if len(parts) != 2 {
+		return requirement{}, fmt.Errorf("malformed set requirement")
+	}
+	key := strings.TrimSpace(parts[0])
+	vals := strings.TrimSpace(parts[1])
+	vals = strings.TrimPrefix(vals, "(")
+	vals = strings.TrimSuffix(vals, ")")
+	return requirement{key: key, op: op, values: toSet(vals)}, nil
+}
+
+// toSet splits a comma-separated value list into a membership set.
+func toSet(vals string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(vals, ",") {
+		set[strings.TrimSpace(v)] = true
+	}
+	return set
+}
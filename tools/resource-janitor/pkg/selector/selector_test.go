@@ -0,0 +1,141 @@
+package selector
+
+import "testing"
+
+func TestParseAndMatches(t *testing.T) {
+	cases := []struct {
+		name   string
+		expr   string
+		labels map[string]string
+		want   bool
+	}{
+		{
+			name:   "empty expression matches everything",
+			expr:   "",
+			labels: map[string]string{"env": "prod"},
+			want:   true,
+		},
+		{
+			name:   "exists requirement matches when key present",
+			expr:   "env",
+			labels: map[string]string{"env": "prod"},
+			want:   true,
+		},
+		{
+			name:   "exists requirement fails when key absent",
+			expr:   "env",
+			labels: map[string]string{"tier": "prod"},
+			want:   false,
+		},
+		{
+			name:   "not-exists requirement matches when key absent",
+			expr:   "!env",
+			labels: map[string]string{"tier": "prod"},
+			want:   true,
+		},
+		{
+			name:   "not-exists requirement fails when key present",
+			expr:   "!env",
+			labels: map[string]string{"env": "prod"},
+			want:   false,
+		},
+		{
+			name:   "equals requirement matches",
+			expr:   "env=dev",
+			labels: map[string]string{"env": "dev"},
+			want:   true,
+		},
+		{
+			name:   "equals requirement fails on mismatch",
+			expr:   "env=dev",
+			labels: map[string]string{"env": "prod"},
+			want:   false,
+		},
+		{
+			name:   "not-equals requirement matches when key absent",
+			expr:   "tier!=prod",
+			labels: map[string]string{"env": "dev"},
+			want:   true,
+		},
+		{
+			name:   "not-equals requirement fails on match",
+			expr:   "tier!=prod",
+			labels: map[string]string{"tier": "prod"},
+			want:   false,
+		},
+		{
+			name:   "in requirement matches any listed value",
+			expr:   "owner in (alice,bob)",
+			labels: map[string]string{"owner": "bob"},
+			want:   true,
+		},
+		{
+			name:   "in requirement fails on unlisted value",
+			expr:   "owner in (alice,bob)",
+			labels: map[string]string{"owner": "carol"},
+			want:   false,
+		},
+		{
+			name:   "notin requirement matches unlisted value",
+			expr:   "owner notin (alice,bob)",
+			labels: map[string]string{"owner": "carol"},
+			want:   true,
+		},
+		{
+			name:   "notin requirement matches when key absent",
+			expr:   "owner notin (alice,bob)",
+			labels: map[string]string{},
+			want:   true,
+		},
+		{
+			name:   "multiple terms are ANDed",
+			expr:   "env=dev,tier!=prod,owner in (alice,bob)",
+			labels: map[string]string{"env": "dev", "tier": "staging", "owner": "alice"},
+			want:   true,
+		},
+		{
+			name:   "multiple terms fail if any term fails",
+			expr:   "env=dev,tier!=prod,owner in (alice,bob)",
+			labels: map[string]string{"env": "dev", "tier": "prod", "owner": "alice"},
+			want:   false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sel, err := Parse(c.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %s", c.expr, err)
+			}
+			if got := sel.Matches(c.labels); got != c.want {
+				t.Errorf("Matches(%v) = %v, want %v", c.labels, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"env=dev,",
+		"env=dev,,tier=prod",
+	}
+
+	for _, expr := range cases {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) returned nil error, want non-nil", expr)
+		}
+	}
+}
+
+func TestSplitRequirementsIgnoresCommasInsideParens(t *testing.T) {
+	got := splitRequirements("env=dev,owner in (alice,bob),tier!=prod")
+	want := []string{"env=dev", "owner in (alice,bob)", "tier!=prod"}
+	if len(got) != len(want) {
+		t.Fatalf("splitRequirements returned %d terms, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("splitRequirements()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
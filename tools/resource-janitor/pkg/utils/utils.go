@@ -2,211 +2,86 @@ package utils
 
 import (
 	"strings"
-	"log"
-	"fmt"
 	"time"
+
 	"google.golang.org/api/compute/v1"
 )
 
-// GetOldAndNonSingletonImages returns a list of images that are older
-// than the specified time and are not the only images with the same naming
-// scheme.
-func GetOldAndNonSingletonImages(computeService *compute.Service, project string, t time.Time, nameDelimiter string) ([]*compute.Image, error) {
-	imageListCall := computeService.Images.List(project)
-
-	allImages := []*compute.Image{}
-	for {
-		imageList, err := imageListCall.Do()
-		if err != nil {
-			return nil, fmt.Errorf("utils.go: Error getting images: %s", err)
-		}
-
-		for _, image := range imageList.Items {
-			allImages = append(allImages, image)
-		}
-		if imageList.NextPageToken == "" {
-			break
-		}
-		imageListCall = imageListCall.PageToken(imageList.NextPageToken)
-	}
-
-	nonSingletonImages := getNonSingletonImages(allImages, nameDelimiter)
-	oldAndNonSingletonImages, err := getOldImages(nonSingletonImages, t)
-	if err != nil {
-		return nil, fmt.Errorf("utils.go: Unable to get old images: %s", err)
-	}
-
-	return oldAndNonSingletonImages, nil
+// GetTooOldTime takes in a number of seconds and returns a time.Time that is that number of
+// seconds from the current time.
+func GetTooOldTime(i int64) time.Time {
+	return time.Unix(time.Now().Unix()-i, 0)
 }
 
-func getNonSingletonImages(imageList []*compute.Image, nameDelimiter string) []*compute.Image {
-	singleton := &compute.Image{}
-	nonSingletons := []*compute.Image{}
-	for i := range imageList {
-		if i == 0 {
-			singleton = imageList[i]
-			continue
-		}
-
-		// Whether or not an image is singleton is tracked using the singleton
-		// variable. If the two currently compared images match, neither can be
-		// a singleton. If the two currently compared images don't match and the
-		// singleton variable is set, the image is a singleton. Otherwise, the
-		// image that is the current `i` value in the loop is a candidate to be a
-		// singleton.
-		if resourceNamesMatch(imageList[i-1].Name, imageList[i].Name, nameDelimiter) {
-			singleton = &compute.Image{}
-			nonSingletons = append(nonSingletons, imageList[i-1])
-			if len(imageList)-1 == i {
-				log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is most recent of its type\"", imageList[i].Name, imageList[i].CreationTimestamp)
-			}
-		} else if singleton.Name != "" {
-			singleton = imageList[i]
-			log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is singleton\"", singleton.Name, singleton.CreationTimestamp)
-		} else {
-			// Ignore the resource (imageList[i-1]) that was matched to a resource at some point
-			// This block is run when the most recent of a certain type is at imageList[i-1]
-			singleton = imageList[i]
-			log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is most recent of its type\"", imageList[i-1].Name, imageList[i-1].CreationTimestamp)
-			if len(imageList)-1 == i {
-				log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is singleton\"", singleton.Name, singleton.CreationTimestamp)
-			}
-		}
-	}
-	return nonSingletons
+// ParseCreationTimestamp is used to parse the creationTimestamp fields
+// returned by the Compute API.
+func ParseCreationTimestamp(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339, s)
 }
 
-func getOldImages(i []*compute.Image, t time.Time) ([]*compute.Image, error) {
-	oldImages := []*compute.Image{}
-
-	for _, image := range i {
-		stamp, err := parseCreationTimestamp(image.CreationTimestamp)
-		if err != nil {
-			return nil, fmt.Errorf("utils.go: Failed to parse timestamp: %v", err)
-		}
-
-		if stamp.Before(t) {
-			log.Printf("utils.go: selected image for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", image.Name, image.CreationTimestamp, t)
-			oldImages = append(oldImages, image)
-		} else {
-			log.Printf("utils.go: excluded image from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", image.Name, image.CreationTimestamp, t)
-		}
-	}
-
-	return oldImages, nil
+// GetResourceNameFromURL takes in a resource's self link (e.g. a zone or
+// region URL) and returns just the resource name.
+func GetResourceNameFromURL(z string) string {
+	splitResource := strings.Split(z, "/")
+	return splitResource[len(splitResource)-1]
 }
 
-// GetOldAndNonSingletonInstances returns a list of instances that are older
-// than the specified time and are not the only instances with the same naming
-// scheme.
-func GetOldAndNonSingletonInstances(computeService *compute.Service, project string, t time.Time, nameDelimiter string) ([]*compute.Instance, error) {
-	zones, err := computeService.Zones.List(project).Do()
-	if err != nil {
-		log.Fatalf("utils.go: Unable to get list of zones: %s", err)
+// NameGroupKey returns the singleton-grouping key for a resource name: the
+// name with its last delimiter-separated token (typically a random or
+// incrementing suffix) stripped. A name that doesn't contain delimiter is
+// its own group, rather than collapsing into an empty key shared by every
+// other undelimited name.
+func NameGroupKey(name string, delimiter string) string {
+	split := strings.Split(name, delimiter)
+	if len(split) == 1 {
+		return name
 	}
-
-	allInstances := []*compute.Instance{}
-
-	for _, zone := range zones.Items {
-		instanceListCall := computeService.Instances.List(project, zone.Name)
-
-		for {
-			instanceList, err := instanceListCall.Do()
-			if err != nil {
-				return nil, fmt.Errorf("utils.go: Error getting instances: %s", err)
-			}
-
-			for _, instance := range instanceList.Items {
-				allInstances = append(allInstances, instance)
-			}
-			if instanceList.NextPageToken == "" {
-				break
-			}
-			instanceListCall = instanceListCall.PageToken(instanceList.NextPageToken)
-		}
-	}
-
-	nonSingletonInstances := getNonSingletonInstances(allInstances, nameDelimiter)
-	oldAndNonSingletonInstances, err := getOldInstances(nonSingletonInstances, t)
-	if err != nil {
-		return nil, fmt.Errorf("utils.go: Unable to get old instances: %s", err)
-	}
-
-	return oldAndNonSingletonInstances, nil
+	return strings.Join(split[:len(split)-1], delimiter)
 }
 
-func getNonSingletonInstances(instanceList []*compute.Instance, nameDelimiter string) []*compute.Instance {
-	singleton := &compute.Instance{}
-	nonSingletons := []*compute.Instance{}
-	for i := range instanceList {
-		if i == 0 {
-			singleton = instanceList[i]
-			continue
-		}
-
-		// Whether or not an instance is singleton is tracked using the singleton
-		// variable. If the two currently compared instances match, neither can be
-		// a singleton. If the two currently compared instances don't match and the
-		// singleton variable is set, the instance is a singleton. Otherwise, the
-		// instance that is the current `i` value in the loop is a candidate to be a
-		// singleton.
-		if resourceNamesMatch(instanceList[i-1].Name, instanceList[i].Name, nameDelimiter) {
-			singleton = &compute.Instance{}
-			nonSingletons = append(nonSingletons, instanceList[i-1])
-			if len(instanceList)-1 == i {
-				log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is most recent of its type\"", instanceList[i].Name, instanceList[i].CreationTimestamp)
-			}
-		} else if singleton.Name != "" {
-			singleton = instanceList[i]
-			log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is singleton\"", singleton.Name, singleton.CreationTimestamp)
-		} else {
-			// Ignore the resource (instanceList[i-1]) that was matched to a resource at some point
-			// This block is run when the most recent of a certain type is at instanceList[i-1]
-			singleton = instanceList[i]
-			log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is most recent of its type\"", instanceList[i-1].Name, instanceList[i-1].CreationTimestamp)
-			if len(instanceList)-1 == i {
-				log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"instance is singleton\"", singleton.Name, singleton.CreationTimestamp)
+// GroupKeys computes the singleton-heuristic grouping key for each
+// resource in names: the value of the groupByLabel label when the
+// resource carries one, falling back to NameGroupKey otherwise. labels[i]
+// holds the labels for names[i]; groupByLabel == "" disables label-based
+// grouping entirely.
+func GroupKeys(names []string, labels []map[string]string, groupByLabel string, nameDelimiter string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		if groupByLabel != "" {
+			if v, ok := labels[i][groupByLabel]; ok {
+				keys[i] = v
+				continue
 			}
 		}
+		keys[i] = NameGroupKey(name, nameDelimiter)
 	}
-	return nonSingletons
+	return keys
 }
 
-func getOldInstances(i []*compute.Instance, t time.Time) ([]*compute.Instance, error) {
-	oldInstances := []*compute.Instance{}
-
-	for _, instance := range i {
-		stamp, err := parseCreationTimestamp(instance.CreationTimestamp)
-		if err != nil {
-			return nil, fmt.Errorf("utils.go: Failed to parse timestamp: %v", err)
+// NonSingletonIndices groups resources by groupKeys (see GroupKeys) and,
+// within each group, keeps the resource with the latest createdAt as the
+// singleton. The returned slice reports, per index, whether that resource
+// is a non-singleton (i.e. not the newest in its group) and therefore
+// eligible for deletion consideration.
+//
+// Unlike the adjacency-based heuristic this replaced, grouping is done by
+// key equality rather than position, so groupKeys/createdAt don't need to
+// be pre-sorted by creation time and groups of any size are handled
+// correctly.
+func NonSingletonIndices(groupKeys []string, createdAt []time.Time) []bool {
+	newest := make(map[string]int, len(groupKeys))
+	for i, key := range groupKeys {
+		best, ok := newest[key]
+		if !ok || createdAt[i].After(createdAt[best]) {
+			newest[key] = i
 		}
+	}
 
-		if stamp.Before(t) {
-			log.Printf("utils.go: selected instance for deletion: name=%s creationTimestamp=%s reason=\"older than %s\"", instance.Name, instance.CreationTimestamp, t)
-			oldInstances = append(oldInstances, instance)
-		} else {
-			log.Printf("utils.go: excluded instance from deletion: name=%s creationTimestamp=%s reason=\"newer than %s\"", instance.Name, instance.CreationTimestamp, t)
+	eligible := make([]bool, len(groupKeys))
+	for i, key := range groupKeys {
+		if newest[key] != i {
+			eligible[i] = true
 		}
 	}
-
-	return oldInstances, nil
-}
-
-// GetTooOldTime takes in a number of seconds and returns a time.Time that is that number of
-// seconds from the current time.
-func GetTooOldTime(i int64) time.Time {
-	return time.Unix(time.Now().Unix()-i, 0)
-}
-
-func parseCreationTimestamp(s string) (time.Time, error) {
-	return time.Parse(time.RFC3339, s)
-}
-
-func resourceNamesMatch(a string, b string, delimiter string) bool {
-	aSplit := strings.Split(a, "-")
-	aName := strings.Join(aSplit[:len(aSplit)-1], "-")
-	bSplit := strings.Split(b, "-")
-	bName := strings.Join(bSplit[:len(bSplit)-1], "-")
-
-	return (aName == bName)
+	return eligible
 }
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNameGroupKey(t *testing.T) {
+	cases := []struct {
+		name      string
+		delimiter string
+		want      string
+	}{
+		{"web-abc123", "-", "web"},
+		{"web-v2-abc123", "-", "web-v2"},
+		{"standalone", "-", "standalone"},
+	}
+
+	for _, c := range cases {
+		if got := NameGroupKey(c.name, c.delimiter); got != c.want {
+			t.Errorf("NameGroupKey(%q, %q) = %q, want %q", c.name, c.delimiter, got, c.want)
+		}
+	}
+}
+
+func TestGroupKeysFallsBackToName(t *testing.T) {
+	names := []string{"web-abc", "web-def", "db-abc"}
+	labels := []map[string]string{{}, {}, {}}
+
+	got := GroupKeys(names, labels, "", "-")
+	want := []string{"web", "web", "db"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupKeys = %v, want %v", got, want)
+	}
+}
+
+func TestGroupKeysPrefersLabel(t *testing.T) {
+	names := []string{"web-abc", "web-def"}
+	labels := []map[string]string{
+		{"group": "frontend"},
+		{},
+	}
+
+	got := GroupKeys(names, labels, "group", "-")
+	want := []string{"frontend", "web"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupKeys = %v, want %v", got, want)
+	}
+}
+
+func TestNonSingletonIndices(t *testing.T) {
+	now := time.Now()
+	groupKeys := []string{"web", "web", "web", "db"}
+	createdAt := []time.Time{
+		now.Add(-2 * time.Hour),
+		now.Add(-1 * time.Hour),
+		now,
+		now,
+	}
+
+	got := NonSingletonIndices(groupKeys, createdAt)
+	want := []bool{true, true, false, false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NonSingletonIndices = %v, want %v", got, want)
+	}
+}
+
+func TestNonSingletonIndicesDoesNotRequireSortedInput(t *testing.T) {
+	now := time.Now()
+	groupKeys := []string{"web", "web", "web"}
+	createdAt := []time.Time{
+		now,
+		now.Add(-2 * time.Hour),
+		now.Add(-1 * time.Hour),
+	}
+
+	got := NonSingletonIndices(groupKeys, createdAt)
+	want := []bool{false, true, true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NonSingletonIndices = %v, want %v", got, want)
+	}
+}
+
+func TestGetResourceNameFromURL(t *testing.T) {
+	url := "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a"
+	if got, want := GetResourceNameFromURL(url), "us-central1-a"; got != want {
+		t.Errorf("GetResourceNameFromURL(%q) = %q, want %q", url, got, want)
+	}
+}